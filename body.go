@@ -12,11 +12,20 @@ package httpx // import "tideland.dev/go/httpx"
 //--------------------
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
 )
 
 //--------------------
@@ -26,86 +35,305 @@ import (
 const (
 	HeaderAccept      = "Accept"
 	HeaderContentType = "Content-Type"
+	HeaderVary        = "Vary"
+
+	ContentTypeJSON        = "application/json"
+	ContentTypePlain       = "text/plain"
+	ContentTypeProblemJSON = "application/problem+json"
+	ContentTypeXML         = "application/xml"
+)
+
+//--------------------
+// BODY ERRORS
+//--------------------
 
-	ContentTypeJSON  = "application/json"
-	ContentTypePlain = "text/plain"
-	ContentTypeXML   = "application/xml"
+// BodyErrorKind tells where reading a body failed.
+type BodyErrorKind int
+
+// Kinds of body errors.
+const (
+	BodyErrorRead BodyErrorKind = iota
+	BodyErrorTooLarge
+	BodyErrorMediaType
+	BodyErrorSyntax
+	BodyErrorType
 )
 
+// BodyError is returned by ReadBody if the body cannot be read or decoded.
+type BodyError struct {
+	Kind BodyErrorKind
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *BodyError) Error() string {
+	return "ReadBody: " + e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *BodyError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode implements the StatusCoder interface. Body too large is
+// answered with a 413, unsupported media types or charsets with a 415,
+// mismatching types with a 422, and all others with a 400.
+func (e *BodyError) StatusCode() int {
+	switch e.Kind {
+	case BodyErrorTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case BodyErrorMediaType:
+		return http.StatusUnsupportedMediaType
+	case BodyErrorType:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// newBodyError creates a body error with a formatted message.
+func newBodyError(kind BodyErrorKind, format string, args ...interface{}) *BodyError {
+	return &BodyError{
+		Kind: kind,
+		Err:  fmt.Errorf(format, args...),
+	}
+}
+
+// decodeErrorKind classifies an error returned by a codec. Mismatching types
+// of JSON and XML values, unknown JSON fields, and invalid form fields are
+// type errors, all others syntax errors.
+func decodeErrorKind(err error) BodyErrorKind {
+	var be *BodyError
+	var fes FieldErrors
+	var jute *json.UnmarshalTypeError
+	var jiue *json.InvalidUnmarshalError
+	var ufe *unknownFieldError
+	var xue xml.UnmarshalError
+	var ne *strconv.NumError
+	switch {
+	case errors.As(err, &be):
+		return be.Kind
+	case errors.As(err, &fes), errors.As(err, &jute), errors.As(err, &jiue), errors.As(err, &ufe):
+		return BodyErrorType
+	case errors.As(err, &xue), errors.As(err, &ne):
+		return BodyErrorType
+	}
+	return BodyErrorSyntax
+}
+
+//--------------------
+// READ OPTIONS
+//--------------------
+
+// readOptions contains the options for reading a body.
+type readOptions struct {
+	maxSize               int64
+	maxFileSize           int64
+	maxMemory             int64
+	disallowUnknownFields bool
+	useNumber             bool
+}
+
+// ReadOption configures the reading of a request body by ReadBody.
+type ReadOption func(o *readOptions)
+
+// MaxBodySize limits the size of the body in bytes. Larger bodies are
+// rejected with a BodyError answering with 413 Request Entity Too Large.
+func MaxBodySize(size int64) ReadOption {
+	return func(o *readOptions) {
+		o.maxSize = size
+	}
+}
+
+// MaxFileSize limits the size of each file of a multipart form in bytes.
+// Larger files are rejected with a BodyError answering with 413 Request
+// Entity Too Large. The total size is limited by MaxBodySize.
+func MaxFileSize(size int64) ReadOption {
+	return func(o *readOptions) {
+		o.maxFileSize = size
+	}
+}
+
+// MaxMemory sets the number of bytes of all parts of a multipart form kept
+// in memory. Files exceeding it are spilled into temporary files. Default
+// is 10 MB.
+func MaxMemory(size int64) ReadOption {
+	return func(o *readOptions) {
+		o.maxMemory = size
+	}
+}
+
+// StrictJSON lets JSON decoding fail on fields unknown to the value.
+func StrictJSON() ReadOption {
+	return func(o *readOptions) {
+		o.disallowUnknownFields = true
+	}
+}
+
+// UseNumber lets JSON decoding unmarshal numbers into interface values
+// as json.Number instead of float64.
+func UseNumber() ReadOption {
+	return func(o *readOptions) {
+		o.useNumber = true
+	}
+}
+
+// newReadOptions applies the options.
+func newReadOptions(options []ReadOption) *readOptions {
+	opts := &readOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// apply configures a JSON codec with the options.
+func (o *readOptions) apply(codec Codec) Codec {
+	jc, ok := codec.(JSONCodec)
+	if !ok {
+		return codec
+	}
+	jc.DisallowUnknownFields = jc.DisallowUnknownFields || o.disallowUnknownFields
+	jc.UseNumber = jc.UseNumber || o.useNumber
+	return jc
+}
+
 //--------------------
 // BODY HANDLING
 //--------------------
 
 // ReadBody reads and unmarshals the body of the request into the given interface. It analyzes the
-// content type and uses the appropriate unmarshaler. Here it handles plain text, JSON, and XML. All
-// other content types are returned directly as byte slice.
-func ReadBody(r *http.Request, value interface{}) error {
-	// Read content type and body.
-	contentType := r.Header.Get(HeaderContentType)
-	body, err := ioutil.ReadAll(r.Body)
+// media type of the content type and uses the registered codec. By default these are plain text,
+// JSON, XML including structured suffixes like application/problem+json, and URL encoded forms.
+// Text and XML in other charsets than UTF-8 are transcoded. Multipart forms are read into a *Form
+// or a struct with fields tagged like `form:"name"`, fields of type *FormFile or []*FormFile get
+// the files. Forms already parsed into the request, e.g. by a middleware, are bound from there.
+// Forms and all other content types are returned directly as byte slice too.
+// The codecs decode the body while it's read, so it isn't buffered as a whole. Failures of reading
+// and decoding are returned as BodyError.
+func ReadBody(r *http.Request, value interface{}, options ...ReadOption) error {
+	opts := newReadOptions(options)
+	// Check content type and open body.
+	mediaType, params, err := parseContentType(r.Header.Get(HeaderContentType))
 	if err != nil {
-		return err
+		return newBodyError(BodyErrorMediaType, "%w", err)
+	}
+	if isParsedForm(r, mediaType, value) {
+		return readParsedForm(r, value)
 	}
-	if err = r.Body.Close(); err != nil {
+	body, err := openBody(r, opts.maxSize)
+	if err != nil {
 		return err
 	}
+	defer body.Close()
 
-	// Unmarshal based on content type.
-	switch contentType {
-	case ContentTypeJSON:
-		err := json.Unmarshal(body, value)
-		if err != nil {
-			return fmt.Errorf("ReadBody: cannot unmarshal body: %v", err)
-		}
-	case ContentTypePlain:
-		pv, ok := value.(*string) // Assume v is a string pointer.
+	// Decode with the codec of the media type.
+	if mediaType == ContentTypeMultipartForm {
+		return readMultipart(body, params["boundary"], value, opts)
+	}
+	codec, ok := codecs.lookup(mediaType)
+	if !ok {
+		pbs, ok := value.(*[]byte) // Assume v is a byte slice pointer.
 		if !ok {
-			return fmt.Errorf("ReadBody: value is not a string pointer")
+			return fmt.Errorf("ReadBody: value is not a byte slice pointer")
 		}
-		*pv = string(body)
-	case ContentTypeXML:
-		err := xml.Unmarshal(body, value)
+		bs, err := ioutil.ReadAll(body)
 		if err != nil {
-			return fmt.Errorf("ReadBody: cannot unmarshal body: %v", err)
+			return err
 		}
-	default:
-		pbs, ok := value.(*[]byte) // Assume v is a byte slice pointer.
-		if !ok {
-			return fmt.Errorf("ReadBody: value is not a byte slice pointer")
+		*pbs = bs
+		return nil
+	}
+	var reader io.Reader = body
+	if isTextual(mediaType) && !isUTF8(params["charset"]) {
+		reader, err = transcodeReader(body, params["charset"])
+		if err != nil {
+			return newBodyError(BodyErrorMediaType, "%w", err)
+		}
+	}
+	if err := opts.apply(codec).Decode(reader, value); err != nil {
+		var be *BodyError
+		if errors.As(err, &be) && (be.Kind == BodyErrorRead || be.Kind == BodyErrorTooLarge) {
+			return be
 		}
-		*pbs = body
+		return newBodyError(decodeErrorKind(err), "cannot unmarshal body: %w", err)
 	}
 	return nil
 }
 
-// WriteBody writes the given value to the response writer. It analyzes the content type and uses the
-// the appropriate encoding. Here it handles plain text, JSON, and XML. All other content types are
-// written directly as byte slice.
-func WriteBody(w http.ResponseWriter, contentType string, value interface{}) (int, error) {
-	// Marshal based on content type.
-	switch contentType {
-	case ContentTypeJSON:
-		body, err := json.Marshal(value)
-		if err != nil {
-			return 0, fmt.Errorf("WriteBody: cannot marshal value: %v", err)
+// bodyReader reads the body of a request. It limits its size if wanted
+// and returns read errors as BodyError.
+type bodyReader struct {
+	body    io.ReadCloser
+	limit   int64
+	remains int64
+}
+
+// openBody checks the content length of the request and returns the
+// reader of its body. A maximum size larger than zero is checked.
+func openBody(r *http.Request, maxSize int64) (*bodyReader, error) {
+	if maxSize > 0 && r.ContentLength > maxSize {
+		_ = r.Body.Close()
+		return nil, newBodyError(BodyErrorTooLarge, "body size %d exceeds limit of %d bytes", r.ContentLength, maxSize)
+	}
+	return &bodyReader{
+		body:    r.Body,
+		limit:   maxSize,
+		remains: maxSize,
+	}, nil
+}
+
+// Read implements io.Reader.
+func (br *bodyReader) Read(p []byte) (int, error) {
+	if br.limit > 0 {
+		if br.remains <= 0 {
+			// Check if there's more than allowed.
+			var probe [1]byte
+			n, err := br.body.Read(probe[:])
+			if n > 0 {
+				return 0, newBodyError(BodyErrorTooLarge, "body exceeds limit of %d bytes", br.limit)
+			}
+			return 0, br.wrap(err)
 		}
-		w.Header().Set(HeaderContentType, ContentTypeJSON)
-		return w.Write(body)
-	case ContentTypePlain:
-		s, ok := value.(string)
-		if !ok {
-			return 0, fmt.Errorf("WriteBody: value is not a string")
+		if int64(len(p)) > br.remains {
+			p = p[:br.remains]
 		}
-		w.Header().Set(HeaderContentType, ContentTypePlain)
-		return w.Write([]byte(s))
-	case ContentTypeXML:
-		body, err := xml.Marshal(value)
-		if err != nil {
-			return 0, fmt.Errorf("WriteBody: cannot marshal value: %v", err)
+	}
+	n, err := br.body.Read(p)
+	br.remains -= int64(n)
+	return n, br.wrap(err)
+}
+
+// Close implements io.Closer.
+func (br *bodyReader) Close() error {
+	return br.body.Close()
+}
+
+// wrap returns read errors as BodyError. Errors of wrapping readers
+// signalling a too large body with their status code keep this kind.
+func (br *bodyReader) wrap(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if ErrorStatusCode(err) == http.StatusRequestEntityTooLarge {
+		return &BodyError{
+			Kind: BodyErrorTooLarge,
+			Err:  err,
 		}
-		w.Header().Set(HeaderContentType, ContentTypeXML)
-		return w.Write(body)
-	default:
+	}
+	return newBodyError(BodyErrorRead, "cannot read body: %w", err)
+}
+
+// WriteBody writes the given value to the response writer. It analyzes the content type and uses the
+// the registered codec. By default these are plain text, JSON, and XML including structured suffixes.
+// All other content types are written directly as byte slice.
+func WriteBody(w http.ResponseWriter, contentType string, value interface{}) (int, error) {
+	mediaType, _, err := parseContentType(contentType)
+	if err != nil {
+		return 0, fmt.Errorf("WriteBody: %v", err)
+	}
+	codec, ok := codecs.lookup(mediaType)
+	if !ok {
 		bs, ok := value.([]byte)
 		if !ok {
 			return 0, fmt.Errorf("WriteBody: value is not a byte slice")
@@ -113,6 +341,78 @@ func WriteBody(w http.ResponseWriter, contentType string, value interface{}) (in
 		w.Header().Set(HeaderContentType, contentType)
 		return w.Write(bs)
 	}
+	// Encode first to not write partial bodies.
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, value); err != nil {
+		return 0, fmt.Errorf("WriteBody: cannot marshal value: %v", err)
+	}
+	w.Header().Set(HeaderContentType, contentType)
+	return w.Write(buf.Bytes())
+}
+
+//--------------------
+// MEDIA TYPES
+//--------------------
+
+// parseContentType returns the lower-case media type and the parameters
+// of a content type. An empty content type is no error.
+func parseContentType(contentType string) (string, map[string]string, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return "", map[string]string{}, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid content type %q: %v", contentType, err)
+	}
+	return mediaType, params, nil
+}
+
+// isTextual checks if the media type is text or XML and so may use a
+// charset.
+func isTextual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || mediaType == ContentTypeXML || strings.HasSuffix(mediaType, "+xml")
+}
+
+//--------------------
+// CHARSETS
+//--------------------
+
+// isUTF8 checks if the charset needs no transcoding.
+func isUTF8(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return true
+	}
+	return false
+}
+
+// transcodeReader returns a reader converting the body in the given
+// charset into UTF-8.
+func transcodeReader(body io.Reader, charset string) (io.Reader, error) {
+	reader, err := charsetReader(charset, body)
+	if err != nil {
+		return nil, err
+	}
+	return &transcodedReader{reader}, nil
+}
+
+// transcodedReader marks a reader whose content already has been
+// transcoded into UTF-8.
+type transcodedReader struct {
+	io.Reader
+}
+
+// charsetReader returns a reader transcoding the input from the charset
+// into UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if isUTF8(charset) {
+		return input, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
 }
 
 // EOF
@@ -12,9 +12,12 @@ package httpx_test // import "tideland.dev/go/httpx"
 //--------------------
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -71,6 +74,41 @@ func TestReadBody(t *testing.T) {
 			contentType: "application/xml",
 			body:        strings.NewReader(`<root><fs>valid</fs><si>1</si>`),
 			err:         "ReadBody: cannot unmarshal body",
+		}, {
+			name:        "JSON with charset",
+			contentType: "application/json; charset=utf-8",
+			body:        strings.NewReader(`{"first_string":"valid", "second_int":1}`),
+			expected:    `1.: "valid" 2.: 1`,
+		}, {
+			name:        "JSON suffix",
+			contentType: "application/vnd.tideland.body+json",
+			body:        strings.NewReader(`{"first_string":"valid", "second_int":1}`),
+			expected:    `1.: "valid" 2.: 1`,
+		}, {
+			name:        "XML suffix",
+			contentType: "application/vnd.tideland.body+xml",
+			body:        strings.NewReader(`<root><fs>valid</fs><si>1</si></root>`),
+			expected:    `1.: "valid" 2.: 1`,
+		}, {
+			name:        "XML in Latin-1",
+			contentType: "text/xml; charset=ISO-8859-1",
+			body:        strings.NewReader("<root><fs>gr\xfcn</fs><si>1</si></root>"),
+			expected:    `1.: "grün" 2.: 1`,
+		}, {
+			name:        "XML with declared Latin-1",
+			contentType: "application/xml",
+			body:        strings.NewReader("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><root><fs>gr\xfcn</fs><si>1</si></root>"),
+			expected:    `1.: "grün" 2.: 1`,
+		}, {
+			name:        "XML with unknown charset",
+			contentType: "application/xml; charset=klingon",
+			body:        strings.NewReader(`<root><fs>valid</fs><si>1</si></root>`),
+			err:         "ReadBody: unsupported charset",
+		}, {
+			name:        "invalid content type",
+			contentType: "application/json; charset",
+			body:        strings.NewReader(`{"first_string":"valid", "second_int":1}`),
+			err:         "ReadBody: invalid content type",
 		},
 	}
 	for i, test := range tests {
@@ -95,6 +133,190 @@ func TestReadBody(t *testing.T) {
 	}
 }
 
+// TestReadBodyText verifies the reading of plain text in different charsets.
+func TestReadBodyText(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		contentType string
+		body        string
+		expected    string
+		err         string
+	}{
+		{"text/plain", "grün", "grün", ""},
+		{"text/plain; charset=UTF-8", "grün", "grün", ""},
+		{"text/plain; charset=iso-8859-1", "gr\xfcn", "grün", ""},
+		{"text/plain; charset=windows-1252", "\x80 5", "€ 5", ""},
+		{"Text/Plain; Charset=latin1", "gr\xfcn", "grün", ""},
+		{"text/plain; charset=klingon", "grün", "", "ReadBody: unsupported charset \"klingon\""},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.contentType)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set(httpx.HeaderContentType, test.contentType)
+		var text string
+		err := httpx.ReadBody(req, &text)
+		if test.err != "" {
+			assert.ErrorContains(err, test.err)
+			assert.Equal(httpx.ErrorStatusCode(err), http.StatusUnsupportedMediaType)
+			continue
+		}
+		assert.NoError(err)
+		assert.Equal(text, test.expected)
+	}
+}
+
+// TestReadBodyOptions verifies the options and typed errors of reading bodies.
+func TestReadBodyOptions(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		name          string
+		body          string
+		unknownLength bool
+		options       []httpx.ReadOption
+		kind          httpx.BodyErrorKind
+		statusCode    int
+		err           string
+	}{
+		{
+			name: "lenient",
+			body: `{"first_string":"valid","second_int":1,"third":true}`,
+		}, {
+			name:       "trailing garbage",
+			body:       `{"first_string":"valid","second_int":1} garbage`,
+			kind:       httpx.BodyErrorSyntax,
+			statusCode: http.StatusBadRequest,
+			err:        "ReadBody: cannot unmarshal body: json: unexpected data after value",
+		}, {
+			name:    "within size",
+			body:    `{"first_string":"valid","second_int":1}`,
+			options: []httpx.ReadOption{httpx.MaxBodySize(64)},
+		}, {
+			name:       "content length too large",
+			body:       `{"first_string":"valid","second_int":1}`,
+			options:    []httpx.ReadOption{httpx.MaxBodySize(16)},
+			kind:       httpx.BodyErrorTooLarge,
+			statusCode: http.StatusRequestEntityTooLarge,
+			err:        "ReadBody: body size 39 exceeds limit of 16 bytes",
+		}, {
+			name:          "read body too large",
+			body:          `{"first_string":"valid","second_int":1}`,
+			unknownLength: true,
+			options:       []httpx.ReadOption{httpx.MaxBodySize(16)},
+			kind:          httpx.BodyErrorTooLarge,
+			statusCode:    http.StatusRequestEntityTooLarge,
+			err:           "ReadBody: body exceeds limit of 16 bytes",
+		}, {
+			name:       "strict unknown field",
+			body:       `{"first_string":"valid","second_int":1,"third":true}`,
+			options:    []httpx.ReadOption{httpx.StrictJSON()},
+			kind:       httpx.BodyErrorType,
+			statusCode: http.StatusUnprocessableEntity,
+			err:        `ReadBody: cannot unmarshal body: json: unknown field "third"`,
+		}, {
+			name:       "trailing data",
+			body:       `{"first_string":"valid","second_int":1} {}`,
+			kind:       httpx.BodyErrorSyntax,
+			statusCode: http.StatusBadRequest,
+			err:        "ReadBody: cannot unmarshal body: json: unexpected data after value",
+		}, {
+			name: "trailing whitespace",
+			body: "{\"first_string\":\"valid\",\"second_int\":1}\n\t ",
+		}, {
+			name:       "syntax",
+			body:       `{"first_string":"valid",`,
+			kind:       httpx.BodyErrorSyntax,
+			statusCode: http.StatusBadRequest,
+			err:        "ReadBody: cannot unmarshal body",
+		}, {
+			name:       "type",
+			body:       `{"first_string":"valid","second_int":"one"}`,
+			kind:       httpx.BodyErrorType,
+			statusCode: http.StatusUnprocessableEntity,
+			err:        "ReadBody: cannot unmarshal body: json: cannot unmarshal string",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
+		if test.unknownLength {
+			req.ContentLength = -1
+		}
+		var b body
+		err := httpx.ReadBody(req, &b, test.options...)
+		if test.err == "" {
+			assert.NoError(err)
+			assert.Equal(b, body{FirstString: "valid", SecondInt: 1})
+			continue
+		}
+		assert.ErrorContains(err, test.err)
+		var berr *httpx.BodyError
+		assert.True(errors.As(err, &berr))
+		assert.Equal(berr.Kind, test.kind)
+		assert.Equal(httpx.ErrorStatusCode(err), test.statusCode)
+	}
+
+	// Numbers as json.Number.
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"big":12345678901234567890}`))
+	req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
+	var m map[string]interface{}
+	err := httpx.ReadBody(req, &m, httpx.UseNumber())
+	assert.NoError(err)
+	assert.Equal(m["big"], json.Number("12345678901234567890"))
+
+	// Error kinds of XML.
+	xmlTests := []struct {
+		body       string
+		kind       httpx.BodyErrorKind
+		statusCode int
+		err        string
+	}{
+		{"<body><fs>valid</fs><si>x</si></body>", httpx.BodyErrorType, http.StatusUnprocessableEntity, `strconv.ParseInt: parsing "x": invalid syntax`},
+		{"<body><fs>valid</fs><si>", httpx.BodyErrorSyntax, http.StatusBadRequest, "XML syntax error"},
+	}
+	for i, test := range xmlTests {
+		assert.Logf("XML test case #%d: %s", i, test.body)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeXML)
+		var b body
+		err := httpx.ReadBody(req, &b)
+		assert.ErrorContains(err, test.err)
+		var berr *httpx.BodyError
+		assert.True(errors.As(err, &berr))
+		assert.Equal(berr.Kind, test.kind)
+		assert.Equal(httpx.ErrorStatusCode(err), test.statusCode)
+	}
+}
+
+// TestWriteBody verifies the writing of values with different content types.
+func TestWriteBody(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	b := body{FirstString: "valid", SecondInt: 1}
+
+	tests := []struct {
+		contentType string
+		value       interface{}
+		expected    string
+	}{
+		{httpx.ContentTypeJSON, b, `{"first_string":"valid","second_int":1}`},
+		{"application/json; charset=utf-8", b, `{"first_string":"valid","second_int":1}`},
+		{httpx.ContentTypeProblemJSON, b, `{"first_string":"valid","second_int":1}`},
+		{"application/atom+xml", b, `<body><fs>valid</fs><si>1</si></body>`},
+		{"text/plain; charset=utf-8", "hello", "hello"},
+		{"application/octet-stream", []byte("raw"), "raw"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.contentType)
+		w := httptest.NewRecorder()
+		_, err := httpx.WriteBody(w, test.contentType, test.value)
+		assert.NoError(err)
+		assert.Equal(w.Header().Get(httpx.HeaderContentType), test.contentType)
+		assert.Equal(w.Body.String(), test.expected)
+	}
+}
+
 //--------------------
 // HELPERS
 //--------------------
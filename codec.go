@@ -0,0 +1,253 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+//--------------------
+// CODEC
+//--------------------
+
+// Codec encodes values into and decodes them from the body of a media type.
+type Codec interface {
+	// Encode writes the encoded value to the writer.
+	Encode(w io.Writer, value interface{}) error
+
+	// Decode reads the encoded value from the reader.
+	Decode(r io.Reader, value interface{}) error
+}
+
+// JSONCodec encodes and decodes JSON. Data following the decoded value is
+// rejected like by json.Unmarshal. Decoding can be configured to fail on
+// unknown fields and to use json.Number for numbers in interface values.
+type JSONCodec struct {
+	DisallowUnknownFields bool
+	UseNumber             bool
+}
+
+// Encode implements Codec.
+func (JSONCodec) Encode(w io.Writer, value interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// Decode implements Codec.
+func (c JSONCodec) Decode(r io.Reader, value interface{}) error {
+	decoder := json.NewDecoder(r)
+	if c.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if c.UseNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(value); err != nil {
+		return jsonDecodeError(err)
+	}
+	return checkJSONEnd(decoder, "value")
+}
+
+// jsonDecodeError marks the error of a field unknown to the value. The
+// json package has no own error type for it.
+func jsonDecodeError(err error) error {
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return &unknownFieldError{err}
+	}
+	return err
+}
+
+// unknownFieldError marks the error of a JSON field unknown to the value.
+type unknownFieldError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *unknownFieldError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *unknownFieldError) Unwrap() error {
+	return e.err
+}
+
+// checkJSONEnd checks that the decoder reached the end of the input. Read
+// errors are returned unchanged.
+func checkJSONEnd(decoder *json.Decoder, what string) error {
+	_, err := decoder.Token()
+	var se *json.SyntaxError
+	switch {
+	case err == io.EOF:
+		return nil
+	case err == nil, errors.As(err, &se):
+		return fmt.Errorf("json: unexpected data after %s", what)
+	}
+	return err
+}
+
+// XMLCodec encodes and decodes XML. Encodings declared in the document
+// are transcoded into UTF-8.
+type XMLCodec struct{}
+
+// Encode implements Codec.
+func (XMLCodec) Encode(w io.Writer, value interface{}) error {
+	body, err := xml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// Decode implements Codec.
+func (XMLCodec) Decode(r io.Reader, value interface{}) error {
+	decoder := xml.NewDecoder(r)
+	if _, ok := r.(*transcodedReader); ok {
+		// Already transcoded based on the content type.
+		decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+	} else {
+		decoder.CharsetReader = charsetReader
+	}
+	return decoder.Decode(value)
+}
+
+// TextCodec encodes strings and decodes into string pointers.
+type TextCodec struct{}
+
+// Encode implements Codec.
+func (TextCodec) Encode(w io.Writer, value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return errors.New("value is not a string")
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// Decode implements Codec.
+func (TextCodec) Decode(r io.Reader, value interface{}) error {
+	pv, ok := value.(*string)
+	if !ok {
+		return errors.New("value is not a string pointer")
+	}
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	*pv = string(text)
+	return nil
+}
+
+//--------------------
+// CODEC REGISTRY
+//--------------------
+
+// codecEntry is a registered codec for a media type.
+type codecEntry struct {
+	mediaType string
+	codec     Codec
+}
+
+// codecRegistry contains the codecs in order of their registration.
+type codecRegistry struct {
+	mu      sync.RWMutex
+	entries []codecEntry
+}
+
+// codecs is the registry used by ReadBody and WriteBody.
+var codecs = &codecRegistry{
+	entries: []codecEntry{
+		{ContentTypeJSON, JSONCodec{}},
+		{ContentTypeXML, XMLCodec{}},
+		{"text/xml", XMLCodec{}},
+		{ContentTypePlain, TextCodec{}},
+		{ContentTypeForm, FormCodec{}},
+	},
+}
+
+// RegisterCodec registers the codec for the media type, e.g. application/cbor.
+// An already registered codec for the media type is replaced. Structured
+// suffixes like application/vnd.api+cbor use the codec of application/cbor
+// if they have none on their own.
+func RegisterCodec(mediaType string, codec Codec) {
+	mediaType = strings.ToLower(mediaType)
+	codecs.mu.Lock()
+	defer codecs.mu.Unlock()
+	for i, entry := range codecs.entries {
+		if entry.mediaType == mediaType {
+			codecs.entries[i].codec = codec
+			return
+		}
+	}
+	codecs.entries = append(codecs.entries, codecEntry{mediaType, codec})
+}
+
+// LookupCodec returns the codec registered for the media type of the
+// content type. Parameters like the charset are ignored.
+func LookupCodec(contentType string) (Codec, bool) {
+	mediaType, _, err := parseContentType(contentType)
+	if err != nil || mediaType == "" {
+		return nil, false
+	}
+	return codecs.lookup(mediaType)
+}
+
+// CodecMediaTypes returns the media types of all registered codecs in
+// order of their registration.
+func CodecMediaTypes() []string {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+	mediaTypes := make([]string, len(codecs.entries))
+	for i, entry := range codecs.entries {
+		mediaTypes[i] = entry.mediaType
+	}
+	return mediaTypes
+}
+
+// lookup returns the codec for the media type or its structured suffix.
+func (cr *codecRegistry) lookup(mediaType string) (Codec, bool) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	if codec, ok := cr.find(mediaType); ok {
+		return codec, true
+	}
+	if idx := strings.LastIndexByte(mediaType, '+'); idx >= 0 {
+		return cr.find("application/" + mediaType[idx+1:])
+	}
+	return nil, false
+}
+
+// find returns the codec registered for exactly the media type.
+func (cr *codecRegistry) find(mediaType string) (Codec, bool) {
+	for _, entry := range cr.entries {
+		if entry.mediaType == mediaType {
+			return entry.codec, true
+		}
+	}
+	return nil, false
+}
+
+// EOF
@@ -0,0 +1,129 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCodecLookup verifies the lookup of the default codecs.
+func TestCodecLookup(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		contentType string
+		codec       httpx.Codec
+		ok          bool
+	}{
+		{"application/json", httpx.JSONCodec{}, true},
+		{"application/json; charset=utf-8", httpx.JSONCodec{}, true},
+		{"Application/JSON", httpx.JSONCodec{}, true},
+		{"application/problem+json", httpx.JSONCodec{}, true},
+		{"application/xml", httpx.XMLCodec{}, true},
+		{"text/xml", httpx.XMLCodec{}, true},
+		{"application/atom+xml", httpx.XMLCodec{}, true},
+		{"text/plain", httpx.TextCodec{}, true},
+		{"application/octet-stream", nil, false},
+		{"application/vnd.unknown+bin", nil, false},
+		{"", nil, false},
+		{"application/json; charset", nil, false},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.contentType)
+		codec, ok := httpx.LookupCodec(test.contentType)
+		assert.Equal(ok, test.ok)
+		assert.Equal(codec, test.codec)
+	}
+
+	mediaTypes := httpx.CodecMediaTypes()
+	assert.True(len(mediaTypes) >= 4)
+	assert.Equal(mediaTypes[:4], []string{"application/json", "application/xml", "text/xml", "text/plain"})
+}
+
+// TestRegisterCodec verifies the usage of registered codecs by ReadBody
+// and WriteBody.
+func TestRegisterCodec(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	httpx.RegisterCodec("application/x-shout", shoutCodec{})
+
+	// Writing.
+	w := httptest.NewRecorder()
+	n, err := httpx.WriteBody(w, "application/x-shout", "hello")
+	assert.NoError(err)
+	assert.Equal(n, 5)
+	assert.Equal(w.Body.String(), "HELLO")
+	assert.Equal(w.Header().Get(httpx.HeaderContentType), "application/x-shout")
+
+	w = httptest.NewRecorder()
+	_, err = httpx.WriteBody(w, "application/x-shout", 42)
+	assert.ErrorContains(err, "WriteBody: cannot marshal value: shout needs a string")
+	assert.Equal(w.Body.Len(), 0)
+
+	// Reading, also with structured suffix.
+	for _, contentType := range []string{"application/x-shout", "application/vnd.test+x-shout"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+		req.Header.Set(httpx.HeaderContentType, contentType)
+		var s string
+		err = httpx.ReadBody(req, &s)
+		assert.NoError(err)
+		assert.Equal(s, "HELLO")
+	}
+
+	// Replacing keeps the order.
+	before := httpx.CodecMediaTypes()
+	httpx.RegisterCodec("Application/X-Shout", httpx.TextCodec{})
+	assert.Equal(httpx.CodecMediaTypes(), before)
+	codec, ok := httpx.LookupCodec("application/x-shout")
+	assert.True(ok)
+	assert.Equal(codec, httpx.TextCodec{})
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// shoutCodec is a test codec converting strings to upper case.
+type shoutCodec struct{}
+
+func (shoutCodec) Encode(w io.Writer, value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return errors.New("shout needs a string")
+	}
+	_, err := io.WriteString(w, strings.ToUpper(s))
+	return err
+}
+
+func (shoutCodec) Decode(r io.Reader, value interface{}) error {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	*value.(*string) = strings.ToUpper(string(bs))
+	return nil
+}
+
+// EOF
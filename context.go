@@ -0,0 +1,24 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// CONTEXT KEYS
+//--------------------
+
+// contextKey is used for values stored by the package in request contexts.
+type contextKey int
+
+const (
+	pathParamsKey contextKey = iota
+	resourcesKey
+	hostPartsKey
+	apiVersionKey
+)
+
+// EOF
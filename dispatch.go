@@ -0,0 +1,230 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//--------------------
+// HOST DISPATCHER
+//--------------------
+
+// hostRoute combines a host pattern with its handler.
+type hostRoute struct {
+	labels    []string
+	wildcards int
+	handler   http.Handler
+}
+
+// HostDispatcher distributes requests to handlers, e.g. nested multiplexers,
+// by the host of the request. Host patterns may contain wildcard labels like
+// in *.tenant.example. The labels matched by the wildcards can be retrieved
+// with HostParts().
+type HostDispatcher struct {
+	mu       sync.RWMutex
+	routes   []*hostRoute
+	fallback http.Handler
+}
+
+// NewHostDispatcher creates a new host dispatcher. Requests for hosts
+// without a matching pattern are answered with 404.
+func NewHostDispatcher() *HostDispatcher {
+	return &HostDispatcher{
+		fallback: http.NotFoundHandler(),
+	}
+}
+
+// Handle registers the handler for the host pattern. Each * in the pattern
+// matches exactly one label of the host. Patterns without wildcards take
+// precedence, otherwise the ones with less wildcards.
+func (d *HostDispatcher) Handle(pattern string, h http.Handler) {
+	hr := &hostRoute{
+		labels:  strings.Split(strings.ToLower(strings.TrimSuffix(pattern, ".")), "."),
+		handler: h,
+	}
+	for _, label := range hr.labels {
+		if label == "*" {
+			hr.wildcards++
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, ehr := range d.routes {
+		if strings.Join(ehr.labels, ".") == strings.Join(hr.labels, ".") {
+			d.routes[i] = hr
+			return
+		}
+	}
+	d.routes = append(d.routes, hr)
+	sort.SliceStable(d.routes, func(i, j int) bool {
+		return d.routes[i].wildcards < d.routes[j].wildcards
+	})
+}
+
+// Default sets the handler for requests for hosts without a matching pattern.
+func (d *HostDispatcher) Default(h http.Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.fallback = h
+}
+
+// ServeHTTP implements http.Handler.
+func (d *HostDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+
+	d.mu.RLock()
+	h := d.fallback
+	var parts []string
+	for _, hr := range d.routes {
+		if hparts, ok := hr.match(labels); ok {
+			h = hr.handler
+			parts = hparts
+			break
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(parts) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), hostPartsKey, parts))
+	}
+	h.ServeHTTP(w, r)
+}
+
+// match checks if the host labels match the pattern and returns the
+// labels matched by wildcards.
+func (hr *hostRoute) match(labels []string) ([]string, bool) {
+	if len(labels) != len(hr.labels) {
+		return nil, false
+	}
+	var parts []string
+	for i, label := range hr.labels {
+		switch {
+		case label == "*" && labels[i] != "":
+			parts = append(parts, labels[i])
+		case label != labels[i]:
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+// HostParts returns the host labels matched by the wildcards of the host
+// pattern in their order.
+func HostParts(r *http.Request) []string {
+	parts, _ := r.Context().Value(hostPartsKey).([]string)
+	return parts
+}
+
+//--------------------
+// VERSION DISPATCHER
+//--------------------
+
+// VersionDispatcher distributes requests to handlers, e.g. nested multiplexers,
+// by the requested API version. It's taken from the configured header or from
+// the version parameter of the media types in the Accept header like in
+// application/json; version=2. The version can be retrieved with APIVersion().
+type VersionDispatcher struct {
+	mu       sync.RWMutex
+	header   string
+	versions map[string]http.Handler
+	fallback http.Handler
+}
+
+// NewVersionDispatcher creates a new version dispatcher reading the version
+// from the given header, e.g. API-Version. With an empty header name only
+// the Accept header is used. Requests without or with an unknown version
+// are answered with 406.
+func NewVersionDispatcher(header string) *VersionDispatcher {
+	return &VersionDispatcher{
+		header:   header,
+		versions: make(map[string]http.Handler),
+		fallback: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unsupported API version", http.StatusNotAcceptable)
+		}),
+	}
+}
+
+// Handle registers the handler for the version.
+func (d *VersionDispatcher) Handle(version string, h http.Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.versions[version] = h
+}
+
+// Default sets the handler for requests without or with an unknown version.
+func (d *VersionDispatcher) Default(h http.Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.fallback = h
+}
+
+// ServeHTTP implements http.Handler.
+func (d *VersionDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	version := d.version(r)
+
+	d.mu.RLock()
+	h, ok := d.versions[version]
+	if !ok {
+		h = d.fallback
+	}
+	d.mu.RUnlock()
+
+	if version != "" {
+		r = r.WithContext(context.WithValue(r.Context(), apiVersionKey, version))
+	}
+	h.ServeHTTP(w, r)
+}
+
+// version retrieves the requested version.
+func (d *VersionDispatcher) version(r *http.Request) string {
+	if d.header != "" {
+		if version := strings.TrimSpace(r.Header.Get(d.header)); version != "" {
+			return version
+		}
+	}
+	for _, accept := range r.Header.Values(HeaderAccept) {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			_, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+			if version := params["version"]; version != "" {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// APIVersion returns the version requested by the client.
+func APIVersion(r *http.Request) string {
+	version, _ := r.Context().Value(apiVersionKey).(string)
+	return version
+}
+
+// EOF
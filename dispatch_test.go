@@ -0,0 +1,178 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/web"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestHostDispatcher tests the dispatching of requests by host.
+func TestHostDispatcher(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	hd := httpx.NewHostDispatcher()
+	hd.Handle("api.example.com", makeHostHandler("api"))
+	hd.Handle("*.tenant.example", makeHostHandler("tenant"))
+	hd.Handle("admin.tenant.example", makeHostHandler("admin"))
+	hd.Handle("*.*.example.org", makeHostHandler("region"))
+
+	s := web.NewSimulator(hd)
+
+	tests := []struct {
+		host       string
+		statusCode int
+		body       string
+	}{
+		{"api.example.com", http.StatusOK, "api: "},
+		{"API.Example.com:8080", http.StatusOK, "api: "},
+		{"acme.tenant.example", http.StatusOK, "tenant: acme"},
+		{"admin.tenant.example", http.StatusOK, "admin: "},
+		{"eu.shop.example.org", http.StatusOK, "region: eu,shop"},
+		{"a.b.tenant.example", http.StatusNotFound, "404 page not found\n"},
+		{"www.example.com", http.StatusNotFound, "404 page not found\n"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.host)
+		req := s.CreateRequest(http.MethodGet, "/", nil)
+		req.Host = test.host
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+
+	// Set a default handler.
+	hd.Default(makeHostHandler("default"))
+	req := s.CreateRequest(http.MethodGet, "/", nil)
+	req.Host = "www.example.com"
+	resp, err := s.Do(req)
+	assert.NoError(err)
+	body, err := web.BodyToString(resp)
+	assert.NoError(err)
+	assert.Equal(body, "default: ")
+}
+
+// TestHostDispatcherBlocking tests that a long running request doesn't
+// block the registering of handlers.
+func TestHostDispatcherBlocking(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	hd := httpx.NewHostDispatcher()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	hd.Handle("stream.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	served := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "stream.example.com"
+		hd.ServeHTTP(httptest.NewRecorder(), req)
+		close(served)
+	}()
+	<-started
+
+	registered := make(chan struct{})
+	go func() {
+		hd.Handle("api.example.com", makeHostHandler("api"))
+		hd.Default(makeHostHandler("default"))
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		assert.Fail("registering blocked by running request")
+	}
+	close(release)
+	<-served
+}
+
+// TestVersionDispatcher tests the dispatching of requests by API version.
+func TestVersionDispatcher(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	v1 := httpx.NewNestedMux("/api")
+	v1.Handle("users", makeVersionHandler("v1"))
+	v2 := httpx.NewNestedMux("/api")
+	v2.Handle("users", makeVersionHandler("v2"))
+	vd := httpx.NewVersionDispatcher("API-Version")
+	vd.Handle("1", v1)
+	vd.Handle("2", v2)
+
+	s := web.NewSimulator(vd)
+
+	tests := []struct {
+		header     string
+		accept     string
+		statusCode int
+		body       string
+	}{
+		{"1", "", http.StatusOK, "v1: 1"},
+		{"2", "application/json", http.StatusOK, "v2: 2"},
+		{"", "application/json; version=1", http.StatusOK, "v1: 1"},
+		{"", "text/html, application/vnd.example+json;version=2;q=0.9", http.StatusOK, "v2: 2"},
+		{"2", "application/json; version=1", http.StatusOK, "v2: 2"},
+		{"3", "", http.StatusNotAcceptable, "unsupported API version\n"},
+		{"", "application/json", http.StatusNotAcceptable, "unsupported API version\n"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %q %q", i, test.header, test.accept)
+		req := s.CreateRequest(http.MethodGet, "/api/users/1", nil)
+		if test.header != "" {
+			req.Header.Set("API-Version", test.header)
+		}
+		if test.accept != "" {
+			req.Header.Set(httpx.HeaderAccept, test.accept)
+		}
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// makeHostHandler creates a handler echoing the matched host parts.
+func makeHostHandler(id string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := id + ": " + strings.Join(httpx.HostParts(r), ",")
+		_, _ = httpx.WriteBody(w, httpx.ContentTypePlain, reply)
+	}
+}
+
+// makeVersionHandler creates a handler echoing the requested API version.
+func makeVersionHandler(id string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := id + ": " + httpx.APIVersion(r)
+		_, _ = httpx.WriteBody(w, httpx.ContentTypePlain, reply)
+	}
+}
+
+// EOF
@@ -13,11 +13,13 @@
 // adding of CORS and ETag headers. Additionally the package contains a convenient
 // way to map the HTTP methods to individual handler methods, like a POST to the
 // method ServerHTTPPost(). In case the handler does not implement the matching
-// method, the error code `http.ErrMethodNotAllowed` will be returned to the client.
+// method, the request is passed to its default ServeHTTP(). In strict mode the
+// client instead gets a 405 Method Not Allowed with a matching Allow header.
 //
 // For RESTful APIs also the nesting of handlers and the parsing of paths for URIs
-// like /api/v1/users/{user-id}/orders/{order-id} are supported. Additionally the
-// work with different content types is simplified.
+// like /api/v1/users/{user-id}/orders/{order-id} are supported. URI templates
+// following RFC 6570 can be expanded and matched against request paths.
+// Additionally the work with different content types is simplified.
 package httpx // import "tideland.dev/go/httpx"
 
 // EOF
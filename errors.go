@@ -0,0 +1,156 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+//--------------------
+// STATUS ERROR
+//--------------------
+
+// StatusCoder is implemented by errors knowing the HTTP status code
+// they shall be answered with.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// StatusError is an error carrying the HTTP status code to answer with.
+type StatusError struct {
+	statusCode int
+	err        error
+}
+
+// NewStatusError wraps the given error together with a status code.
+func NewStatusError(statusCode int, err error) *StatusError {
+	return &StatusError{
+		statusCode: statusCode,
+		err:        err,
+	}
+}
+
+// StatusErrorf creates a status error with a formatted message.
+func StatusErrorf(statusCode int, format string, args ...interface{}) *StatusError {
+	return NewStatusError(statusCode, fmt.Errorf(format, args...))
+}
+
+// Error implements the error interface.
+func (e *StatusError) Error() string {
+	if e.err == nil {
+		return http.StatusText(e.statusCode)
+	}
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// StatusCode implements the StatusCoder interface.
+func (e *StatusError) StatusCode() int {
+	return e.statusCode
+}
+
+// ErrorStatusCode returns the status code of the first error in the chain
+// implementing StatusCoder. Otherwise it's an internal server error.
+func ErrorStatusCode(err error) int {
+	var sc StatusCoder
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
+//--------------------
+// ERROR RENDERING
+//--------------------
+
+// ErrorRenderer writes an error as response.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, err error)
+
+// ErrorFeedback is the body written by RenderError for JSON and XML.
+type ErrorFeedback struct {
+	XMLName    xml.Name `json:"-" xml:"error"`
+	StatusCode int      `json:"statusCode" xml:"statusCode"`
+	Message    string   `json:"message" xml:"message"`
+}
+
+// RenderError is the default ErrorRenderer. It answers with the status code
+// of the error and negotiates plain text, JSON, or XML based on the Accept header
+// of the request. Plain text is preferred.
+func RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	statusCode := ErrorStatusCode(err)
+	feedback := ErrorFeedback{
+		StatusCode: statusCode,
+		Message:    err.Error(),
+	}
+	w.Header().Add(HeaderVary, HeaderAccept)
+	switch contentType := Negotiate(r, ContentTypePlain, ContentTypeJSON, ContentTypeXML); contentType {
+	case ContentTypeJSON, ContentTypeXML:
+		w.Header().Set(HeaderContentType, contentType)
+		w.WriteHeader(statusCode)
+		_, _ = WriteBody(w, contentType, feedback)
+	default:
+		http.Error(w, feedback.Message, statusCode)
+	}
+}
+
+//--------------------
+// PROBLEM DETAILS
+//--------------------
+
+// Problem describes an error response following RFC 7807.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// WriteProblem writes the problem as application/problem+json with its
+// status code.
+func WriteProblem(w http.ResponseWriter, p Problem) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("WriteProblem: cannot marshal problem: %v", err)
+	}
+	statusCode := p.Status
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+	w.Header().Set(HeaderContentType, ContentTypeProblemJSON)
+	w.WriteHeader(statusCode)
+	_, err = w.Write(body)
+	return err
+}
+
+// ProblemHandler returns a handler answering all requests with a problem
+// for the status code. It can be used as NotFound or MethodNotAllowed
+// handler of the nested multiplexer.
+func ProblemHandler(statusCode int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = WriteProblem(w, Problem{
+			Title:    http.StatusText(statusCode),
+			Status:   statusCode,
+			Instance: r.URL.Path,
+		})
+	})
+}
+
+// EOF
@@ -0,0 +1,48 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestErrorStatusCode tests the retrieval of status codes from errors.
+func TestErrorStatusCode(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	err := httpx.StatusErrorf(http.StatusNotFound, "user %q not found", "foo")
+	assert.ErrorContains(err, `user "foo" not found`)
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusNotFound)
+
+	wrapped := fmt.Errorf("handler: %w", err)
+	assert.Equal(httpx.ErrorStatusCode(wrapped), http.StatusNotFound)
+	assert.True(errors.Is(wrapped, err))
+
+	err = httpx.NewStatusError(http.StatusForbidden, nil)
+	assert.ErrorContains(err, "Forbidden")
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusForbidden)
+
+	assert.Equal(httpx.ErrorStatusCode(errors.New("ouch")), http.StatusInternalServerError)
+}
+
+// EOF
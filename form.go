@@ -0,0 +1,404 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	ContentTypeForm          = "application/x-www-form-urlencoded"
+	ContentTypeMultipartForm = "multipart/form-data"
+
+	// defaultMaxMemory is the default number of bytes of all
+	// multipart files kept in memory.
+	defaultMaxMemory = 10 << 20
+)
+
+//--------------------
+// FORM
+//--------------------
+
+// Form contains the values and files of a submitted form.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*FormFile
+}
+
+// RemoveAll closes all files of the form and removes their
+// temporary files.
+func (f *Form) RemoveAll() error {
+	var first error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if err := file.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// FormFile is a file part of a multipart form. Its content is read
+// like any io.Reader. Small files are kept in memory, large ones are
+// spilled into a temporary file removed when closing the form file.
+type FormFile struct {
+	Filename    string
+	ContentType string
+	Size        int64
+
+	reader io.Reader
+	file   *os.File
+	closer io.Closer
+}
+
+// Read implements io.Reader.
+func (ff *FormFile) Read(p []byte) (int, error) {
+	if ff.reader == nil {
+		return 0, errors.New("FormFile: file is closed")
+	}
+	return ff.reader.Read(p)
+}
+
+// Close implements io.Closer. A temporary file is removed, files of forms
+// already parsed into the request are only closed.
+func (ff *FormFile) Close() error {
+	ff.reader = nil
+	if ff.closer != nil {
+		closer := ff.closer
+		ff.closer = nil
+		return closer.Close()
+	}
+	if ff.file == nil {
+		return nil
+	}
+	file := ff.file
+	ff.file = nil
+	err := file.Close()
+	if rerr := os.Remove(file.Name()); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+//--------------------
+// FORM CODEC
+//--------------------
+
+// FormCodec encodes and decodes URL encoded forms. Values are decoded into
+// a *url.Values, a *Form, or a struct with fields tagged like `form:"name"`.
+// See ReadQuery for the supported field types. A *[]byte gets the raw form.
+// Encoded can be url.Values.
+type FormCodec struct{}
+
+// Encode implements Codec.
+func (FormCodec) Encode(w io.Writer, value interface{}) error {
+	var values url.Values
+	switch tv := value.(type) {
+	case url.Values:
+		values = tv
+	case map[string][]string:
+		values = tv
+	default:
+		return errors.New("value is not url.Values")
+	}
+	_, err := io.WriteString(w, values.Encode())
+	return err
+}
+
+// Decode implements Codec.
+func (FormCodec) Decode(r io.Reader, value interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if pbs, ok := value.(*[]byte); ok {
+		*pbs = data
+		return nil
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch tv := value.(type) {
+	case *url.Values:
+		*tv = values
+		return nil
+	case *Form:
+		tv.Values = values
+		tv.Files = map[string][]*FormFile{}
+		return nil
+	}
+	return bindValues(values, "form", value)
+}
+
+//--------------------
+// PARSED FORMS
+//--------------------
+
+// isParsedForm checks if the form of the request has already been parsed,
+// e.g. by a middleware. A *[]byte always gets the raw body.
+func isParsedForm(r *http.Request, mediaType string, value interface{}) bool {
+	if _, ok := value.(*[]byte); ok {
+		return false
+	}
+	switch mediaType {
+	case ContentTypeForm:
+		return r.PostForm != nil
+	case ContentTypeMultipartForm:
+		return r.MultipartForm != nil
+	}
+	return false
+}
+
+// readParsedForm binds the already parsed form of the request to the value.
+// Its files stay owned by the request and are only opened.
+func readParsedForm(r *http.Request, value interface{}) error {
+	form := &Form{
+		Values: r.PostForm,
+		Files:  map[string][]*FormFile{},
+	}
+	if r.MultipartForm != nil {
+		form.Values = url.Values(r.MultipartForm.Value)
+		for name, headers := range r.MultipartForm.File {
+			for _, header := range headers {
+				file, err := openFormFile(header)
+				if err != nil {
+					_ = form.RemoveAll()
+					return newBodyError(BodyErrorRead, "cannot open file %q: %w", header.Filename, err)
+				}
+				form.Files[name] = append(form.Files[name], file)
+			}
+		}
+	}
+	return bindForm(form, value)
+}
+
+// openFormFile opens a file of an already parsed multipart form.
+func openFormFile(header *multipart.FileHeader) (*FormFile, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	file := &FormFile{
+		Filename:    header.Filename,
+		ContentType: header.Header.Get(HeaderContentType),
+		Size:        header.Size,
+		reader:      f,
+		closer:      f,
+	}
+	if file.ContentType == "" {
+		file.ContentType = "application/octet-stream"
+	}
+	return file, nil
+}
+
+// bindForm binds the form to a *url.Values, a *Form, or a struct pointer.
+// Files not bound to fields of the value are closed.
+func bindForm(form *Form, value interface{}) error {
+	switch tv := value.(type) {
+	case *url.Values:
+		_ = form.RemoveAll()
+		*tv = form.Values
+		return nil
+	case *Form:
+		*tv = *form
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		_ = form.RemoveAll()
+		return errors.New("ReadBody: value is neither form nor struct pointer")
+	}
+	b := &binder{
+		values: form.Values,
+		files:  form.Files,
+		bound:  map[*FormFile]bool{},
+		tag:    "form",
+	}
+	err := b.bind(rv)
+	for _, files := range form.Files {
+		for _, file := range files {
+			if err != nil || !b.bound[file] {
+				_ = file.Close()
+			}
+		}
+	}
+	if err != nil {
+		return newBodyError(BodyErrorType, "cannot bind form: %w", err)
+	}
+	return nil
+}
+
+//--------------------
+// MULTIPART FORMS
+//--------------------
+
+// readMultipart reads the multipart form of the body and binds it to the value.
+// A *[]byte gets the raw body.
+func readMultipart(body io.Reader, boundary string, value interface{}, opts *readOptions) error {
+	if pbs, ok := value.(*[]byte); ok {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		*pbs = data
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	switch value.(type) {
+	case *url.Values, *Form:
+	default:
+		if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+			return errors.New("ReadBody: value is neither form nor struct pointer")
+		}
+	}
+	if boundary == "" {
+		return newBodyError(BodyErrorMediaType, "multipart form without boundary")
+	}
+	parsed, err := parseMultipart(multipart.NewReader(body, boundary), opts)
+	if err != nil {
+		return err
+	}
+	return bindForm(parsed, value)
+}
+
+// parseMultipart reads all parts of the multipart form.
+func parseMultipart(mr *multipart.Reader, opts *readOptions) (*Form, error) {
+	form := &Form{
+		Values: url.Values{},
+		Files:  map[string][]*FormFile{},
+	}
+	memory := opts.maxMemory
+	if memory <= 0 {
+		memory = defaultMaxMemory
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			_ = form.RemoveAll()
+			return nil, multipartError(err)
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			// Plain form value, limited by the available memory.
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, part, memory+1)
+			if err != nil && err != io.EOF {
+				_ = form.RemoveAll()
+				return nil, multipartError(err)
+			}
+			if n > memory {
+				_ = form.RemoveAll()
+				return nil, newBodyError(BodyErrorTooLarge, "multipart form exceeds memory limit of %d bytes", memory)
+			}
+			memory -= n
+			form.Values.Add(name, buf.String())
+			continue
+		}
+		file, err := readFormFile(part, &memory, opts.maxFileSize)
+		if err != nil {
+			_ = form.RemoveAll()
+			return nil, err
+		}
+		form.Files[name] = append(form.Files[name], file)
+	}
+}
+
+// readFormFile reads a file part. It's kept in memory as long as the remaining
+// memory is sufficient, otherwise it's spilled into a temporary file.
+func readFormFile(part *multipart.Part, memory *int64, maxSize int64) (*FormFile, error) {
+	file := &FormFile{
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get(HeaderContentType),
+	}
+	if file.ContentType == "" {
+		file.ContentType = "application/octet-stream"
+	}
+	var content io.Reader = part
+	if maxSize > 0 {
+		content = io.LimitReader(part, maxSize+1)
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, content, *memory+1)
+	if err != nil && err != io.EOF {
+		return nil, multipartError(err)
+	}
+	if n <= *memory {
+		// Fits into memory.
+		if maxSize > 0 && n > maxSize {
+			return nil, fileTooLarge(file.Filename, maxSize)
+		}
+		*memory -= n
+		file.Size = n
+		file.reader = bytes.NewReader(buf.Bytes())
+		return file, nil
+	}
+	// Spill into temporary file.
+	tmp, err := ioutil.TempFile("", "httpx-multipart-")
+	if err != nil {
+		return nil, fmt.Errorf("ReadBody: cannot create temporary file: %v", err)
+	}
+	file.file = tmp
+	size, err := io.Copy(tmp, io.MultiReader(&buf, content))
+	if err != nil {
+		_ = file.Close()
+		return nil, multipartError(err)
+	}
+	if maxSize > 0 && size > maxSize {
+		_ = file.Close()
+		return nil, fileTooLarge(file.Filename, maxSize)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("ReadBody: cannot rewind temporary file: %v", err)
+	}
+	file.Size = size
+	file.reader = tmp
+	return file, nil
+}
+
+// fileTooLarge returns the error for a file exceeding the maximum size.
+func fileTooLarge(filename string, maxSize int64) error {
+	return newBodyError(BodyErrorTooLarge, "file %q exceeds limit of %d bytes", filename, maxSize)
+}
+
+// multipartError keeps read errors of the body, other errors are
+// syntax errors of the multipart form.
+func multipartError(err error) error {
+	var be *BodyError
+	if errors.As(err, &be) {
+		return be
+	}
+	return newBodyError(BodyErrorSyntax, "cannot read multipart form: %w", err)
+}
+
+// EOF
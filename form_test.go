@@ -0,0 +1,325 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestReadBodyForm verifies the reading of URL encoded forms.
+func TestReadBodyForm(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	newRequest := func(content string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(content))
+		req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeForm+"; charset=utf-8")
+		return req
+	}
+
+	var p profile
+	err := httpx.ReadBody(newRequest("name=John+Doe&age=42&tag=a&tag=b"), &p)
+	assert.NoError(err)
+	assert.Equal(p.Name, "John Doe")
+	assert.Equal(p.Age, 42)
+	assert.Equal(p.Tags, []string{"a", "b"})
+	assert.Nil(p.Avatar)
+
+	var values url.Values
+	err = httpx.ReadBody(newRequest("name=John+Doe&age=42"), &values)
+	assert.NoError(err)
+	assert.Equal(values.Get("name"), "John Doe")
+
+	var form httpx.Form
+	err = httpx.ReadBody(newRequest("name=John+Doe&age=42"), &form)
+	assert.NoError(err)
+	assert.Equal(form.Values.Get("age"), "42")
+	assert.Length(form.Files, 0)
+
+	err = httpx.ReadBody(newRequest("name=John+Doe&age=old"), &p)
+	assert.ErrorContains(err, "ReadBody: cannot unmarshal body: cannot bind fields")
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusUnprocessableEntity)
+	var fes httpx.FieldErrors
+	assert.True(errors.As(err, &fes))
+	assert.Equal(fes[0].Key, "age")
+
+	var raw []byte
+	err = httpx.ReadBody(newRequest("name=John+Doe&age=42"), &raw)
+	assert.NoError(err)
+	assert.Equal(string(raw), "name=John+Doe&age=42")
+
+	// Form already parsed, e.g. by a middleware.
+	req := newRequest("name=John+Doe&age=42&tag=a")
+	assert.NoError(req.ParseForm())
+	p = profile{}
+	err = httpx.ReadBody(req, &p)
+	assert.NoError(err)
+	assert.Equal(p.Name, "John Doe")
+	assert.Equal(p.Age, 42)
+	assert.Equal(p.Tags, []string{"a"})
+
+	w := httptest.NewRecorder()
+	_, err = httpx.WriteBody(w, httpx.ContentTypeForm, url.Values{"name": {"John Doe"}})
+	assert.NoError(err)
+	assert.Equal(w.Body.String(), "name=John+Doe")
+}
+
+// TestReadBodyMultipart verifies the reading of multipart forms with files
+// in memory and in temporary files.
+func TestReadBodyMultipart(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	large := strings.Repeat("x", 100)
+	req := newMultipartRequest(assert, map[string]string{
+		"name": "John Doe",
+		"age":  "42",
+	}, []testFile{
+		{"avatar", "me.png", "image/png", "small"},
+		{"docs", "a.txt", "text/plain", "first document"},
+		{"docs", "b.txt", "", large},
+	})
+	tmpBefore := countTempFiles(assert)
+
+	var p profile
+	err := httpx.ReadBody(req, &p, httpx.MaxMemory(32))
+	assert.NoError(err)
+	assert.Equal(p.Name, "John Doe")
+	assert.Equal(p.Age, 42)
+	assert.NotNil(p.Avatar)
+	assert.Equal(p.Avatar.Filename, "me.png")
+	assert.Equal(p.Avatar.ContentType, "image/png")
+	assert.Equal(p.Avatar.Size, int64(5))
+	content, err := ioutil.ReadAll(p.Avatar)
+	assert.NoError(err)
+	assert.Equal(string(content), "small")
+	assert.Length(p.Docs, 2)
+	assert.Equal(p.Docs[1].Filename, "b.txt")
+	assert.Equal(p.Docs[1].ContentType, "application/octet-stream")
+	assert.Equal(p.Docs[1].Size, int64(100))
+	assert.Equal(countTempFiles(assert), tmpBefore+1)
+	content, err = ioutil.ReadAll(p.Docs[1])
+	assert.NoError(err)
+	assert.Equal(string(content), large)
+
+	for _, file := range append(p.Docs, p.Avatar) {
+		assert.NoError(file.Close())
+	}
+	assert.Equal(countTempFiles(assert), tmpBefore)
+	_, err = p.Avatar.Read(make([]byte, 1))
+	assert.ErrorContains(err, "FormFile: file is closed")
+
+	// Reading into a form.
+	req = newMultipartRequest(assert, map[string]string{"name": "Jane"}, []testFile{
+		{"docs", "b.txt", "text/plain", large},
+	})
+	var form httpx.Form
+	err = httpx.ReadBody(req, &form, httpx.MaxMemory(32))
+	assert.NoError(err)
+	assert.Equal(form.Values.Get("name"), "Jane")
+	assert.Length(form.Files["docs"], 1)
+	assert.Equal(countTempFiles(assert), tmpBefore+1)
+	assert.NoError(form.RemoveAll())
+	assert.Equal(countTempFiles(assert), tmpBefore)
+
+	// Files not bound are removed.
+	req = newMultipartRequest(assert, map[string]string{"name": "Jane"}, []testFile{
+		{"unknown", "b.txt", "text/plain", large},
+	})
+	err = httpx.ReadBody(req, &p, httpx.MaxMemory(32))
+	assert.NoError(err)
+	assert.Equal(countTempFiles(assert), tmpBefore)
+
+	// Reading the raw body.
+	req = newMultipartRequest(assert, map[string]string{"name": "Jane"}, nil)
+	var raw []byte
+	err = httpx.ReadBody(req, &raw)
+	assert.NoError(err)
+	assert.Contains(`name="name"`, string(raw))
+
+	// Form already parsed, e.g. by a middleware.
+	req = newMultipartRequest(assert, map[string]string{"name": "Jane", "age": "42"}, []testFile{
+		{"avatar", "me.png", "image/png", "small"},
+		{"docs", "b.txt", "", large},
+	})
+	assert.NoError(req.ParseMultipartForm(32))
+	p = profile{}
+	err = httpx.ReadBody(req, &p)
+	assert.NoError(err)
+	assert.Equal(p.Name, "Jane")
+	assert.Equal(p.Age, 42)
+	assert.Equal(p.Avatar.Filename, "me.png")
+	assert.Equal(p.Avatar.ContentType, "image/png")
+	content, err = ioutil.ReadAll(p.Avatar)
+	assert.NoError(err)
+	assert.Equal(string(content), "small")
+	assert.Length(p.Docs, 1)
+	assert.Equal(p.Docs[0].ContentType, "application/octet-stream")
+	content, err = ioutil.ReadAll(p.Docs[0])
+	assert.NoError(err)
+	assert.Equal(string(content), large)
+	for _, file := range append(p.Docs, p.Avatar) {
+		assert.NoError(file.Close())
+	}
+	assert.NoError(req.MultipartForm.RemoveAll())
+}
+
+// TestReadBodyMultipartErrors verifies the limits and errors of reading
+// multipart forms.
+func TestReadBodyMultipartErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	large := strings.Repeat("x", 100)
+	tmpBefore := countTempFiles(assert)
+
+	tests := []struct {
+		name       string
+		values     map[string]string
+		files      []testFile
+		options    []httpx.ReadOption
+		statusCode int
+		err        string
+	}{
+		{
+			name:       "file in memory too large",
+			files:      []testFile{{"avatar", "me.png", "image/png", large}},
+			options:    []httpx.ReadOption{httpx.MaxFileSize(50)},
+			statusCode: http.StatusRequestEntityTooLarge,
+			err:        `ReadBody: file "me.png" exceeds limit of 50 bytes`,
+		}, {
+			name: "spilled file too large",
+			files: []testFile{
+				{"docs", "a.txt", "text/plain", "fine"},
+				{"docs", "b.txt", "text/plain", large},
+			},
+			options:    []httpx.ReadOption{httpx.MaxFileSize(50), httpx.MaxMemory(10)},
+			statusCode: http.StatusRequestEntityTooLarge,
+			err:        `ReadBody: file "b.txt" exceeds limit of 50 bytes`,
+		}, {
+			name:       "total too large",
+			files:      []testFile{{"docs", "b.txt", "text/plain", large}, {"docs", "c.txt", "text/plain", large}},
+			options:    []httpx.ReadOption{httpx.MaxBodySize(300), httpx.MaxMemory(10)},
+			statusCode: http.StatusRequestEntityTooLarge,
+			err:        "ReadBody: body exceeds limit of 300 bytes",
+		}, {
+			name:       "value too large",
+			values:     map[string]string{"name": large},
+			options:    []httpx.ReadOption{httpx.MaxMemory(10)},
+			statusCode: http.StatusRequestEntityTooLarge,
+			err:        "ReadBody: multipart form exceeds memory limit of 10 bytes",
+		}, {
+			name:       "invalid value",
+			values:     map[string]string{"age": "old"},
+			files:      []testFile{{"docs", "b.txt", "text/plain", large}},
+			options:    []httpx.ReadOption{httpx.MaxMemory(10)},
+			statusCode: http.StatusUnprocessableEntity,
+			err:        "ReadBody: cannot bind form: cannot bind fields",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := newMultipartRequest(assert, test.values, test.files)
+		req.ContentLength = -1
+		var p profile
+		err := httpx.ReadBody(req, &p, test.options...)
+		assert.ErrorContains(err, test.err)
+		assert.Equal(httpx.ErrorStatusCode(err), test.statusCode)
+		assert.Equal(countTempFiles(assert), tmpBefore)
+	}
+
+	// Missing boundary and invalid content.
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("no form"))
+	req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeMultipartForm)
+	var p profile
+	err := httpx.ReadBody(req, &p)
+	assert.ErrorContains(err, "ReadBody: multipart form without boundary")
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusUnsupportedMediaType)
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("no form"))
+	req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeMultipartForm+"; boundary=xyz")
+	err = httpx.ReadBody(req, &p)
+	assert.ErrorContains(err, "ReadBody: cannot read multipart form")
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusBadRequest)
+
+	req = newMultipartRequest(assert, map[string]string{"name": "Jane"}, nil)
+	var s string
+	err = httpx.ReadBody(req, &s)
+	assert.ErrorContains(err, "ReadBody: value is neither form nor struct pointer")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// profile is the target of form tests.
+type profile struct {
+	Name   string            `form:"name"`
+	Age    int               `form:"age"`
+	Tags   []string          `form:"tag"`
+	Avatar *httpx.FormFile   `form:"avatar"`
+	Docs   []*httpx.FormFile `form:"docs"`
+}
+
+// testFile describes a file part of a multipart test request.
+type testFile struct {
+	field       string
+	filename    string
+	contentType string
+	content     string
+}
+
+// newMultipartRequest creates a request with a multipart form.
+func newMultipartRequest(assert *asserts.Asserts, values map[string]string, files []testFile) *http.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range values {
+		assert.NoError(mw.WriteField(name, value))
+	}
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+file.field+`"; filename="`+file.filename+`"`)
+		if file.contentType != "" {
+			header.Set(httpx.HeaderContentType, file.contentType)
+		}
+		pw, err := mw.CreatePart(header)
+		assert.NoError(err)
+		_, err = pw.Write([]byte(file.content))
+		assert.NoError(err)
+	}
+	assert.NoError(mw.Close())
+	req := httptest.NewRequest(http.MethodPost, "/", &buf)
+	req.Header.Set(httpx.HeaderContentType, mw.FormDataContentType())
+	return req
+}
+
+// countTempFiles counts the temporary files of multipart forms.
+func countTempFiles(assert *asserts.Asserts) int {
+	names, err := filepath.Glob(filepath.Join(os.TempDir(), "httpx-multipart-*"))
+	assert.NoError(err)
+	return len(names)
+}
+
+// EOF
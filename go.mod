@@ -3,6 +3,8 @@ module tideland.dev/go/httpx
 go 1.17
 
 require (
+	github.com/klauspost/compress v1.15.0
+	golang.org/x/text v0.3.7
 	tideland.dev/go/audit v0.6.5
 	tideland.dev/go/jwt v0.1.0
 	tideland.dev/go/wait v0.2.0
@@ -16,6 +16,8 @@ import (
 	"net/http"
 
 	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
 )
 
 //--------------------
@@ -34,4 +36,39 @@ func makeEchoHandler(assert *asserts.Asserts, id string) http.HandlerFunc {
 	}
 }
 
+// makeParamsHandler creates a handler echoing the named path parameters.
+func makeParamsHandler(assert *asserts.Asserts, id string, names ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := id + ":"
+		for _, name := range names {
+			reply += fmt.Sprintf(" %s=%s", name, httpx.PathParam(r, name))
+		}
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(reply)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// makeErrorHandler creates a handler rendering the errors returned
+// by the function.
+func makeErrorHandler(f func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := f(w, r); err != nil {
+			httpx.RenderError(w, r, err)
+		}
+	}
+}
+
+// makeTagWrapper creates a wrapper adding the tag to the X-Tag header.
+func makeTagWrapper(tag string) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Tag", tag)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
 // EOF
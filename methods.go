@@ -12,7 +12,21 @@ package httpx // import "tideland.dev/go/httpx"
 //--------------------
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	HeaderAllow         = "Allow"
+	HeaderContentLength = "Content-Length"
 )
 
 //--------------------
@@ -73,78 +87,397 @@ type TraceHandler interface {
 	ServeHTTPTrace(w http.ResponseWriter, r *http.Request)
 }
 
+//--------------------
+// ERROR RETURNING METHOD HANDLER INTERFACES
+//--------------------
+
+// GetHandlerE can be implemented by a handler for GET requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type GetHandlerE interface {
+	ServeHTTPGetE(w http.ResponseWriter, r *http.Request) error
+}
+
+// HeadHandlerE can be implemented by a handler for HEAD requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type HeadHandlerE interface {
+	ServeHTTPHeadE(w http.ResponseWriter, r *http.Request) error
+}
+
+// PostHandlerE can be implemented by a handler for POST requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type PostHandlerE interface {
+	ServeHTTPPostE(w http.ResponseWriter, r *http.Request) error
+}
+
+// PutHandlerE can be implemented by a handler for PUT requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type PutHandlerE interface {
+	ServeHTTPPutE(w http.ResponseWriter, r *http.Request) error
+}
+
+// PatchHandlerE can be implemented by a handler for PATCH requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type PatchHandlerE interface {
+	ServeHTTPPatchE(w http.ResponseWriter, r *http.Request) error
+}
+
+// DeleteHandlerE can be implemented by a handler for DELETE requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type DeleteHandlerE interface {
+	ServeHTTPDeleteE(w http.ResponseWriter, r *http.Request) error
+}
+
+// ConnectHandlerE can be implemented by a handler for CONNECT requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type ConnectHandlerE interface {
+	ServeHTTPConnectE(w http.ResponseWriter, r *http.Request) error
+}
+
+// OptionsHandlerE can be implemented by a handler for OPTIONS requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type OptionsHandlerE interface {
+	ServeHTTPOptionsE(w http.ResponseWriter, r *http.Request) error
+}
+
+// TraceHandlerE can be implemented by a handler for TRACE requests dispatched
+// through the MethodHandler. A returned error is rendered by the configured
+// ErrorRenderer.
+type TraceHandlerE interface {
+	ServeHTTPTraceE(w http.ResponseWriter, r *http.Request) error
+}
+
 //--------------------
 // METHOD HANDLER
 //--------------------
 
+// standardMethods contains the HTTP methods known by the MethodHandler
+// in the order they are listed in an Allow header.
+var standardMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// MethodHandlerConfig allows to control how the method handler works.
+// Default values are:
+//  - Strict:        false
+//  - ErrorRenderer: RenderError
+type MethodHandlerConfig struct {
+	// Strict lets the method handler answer requests for methods
+	// not implemented by the wrapped handler with a 405 and an
+	// Allow header instead of calling its ServeHTTP() method.
+	Strict bool
+
+	// ErrorRenderer writes the errors returned by the methods of
+	// the error returning handler interfaces.
+	ErrorRenderer ErrorRenderer
+}
+
 // MethodHandler wraps a http.Handler implementing also individual httpx handler
 // interfaces. It distributes the requests to the handler methods if those are
-// implemented.
+// implemented. Alternatively the handler can implement the error returning
+// variants of the interfaces. Extension methods like the WebDAV ones can be
+// registered individually. If the handler implements GET but not HEAD the HEAD
+// requests are answered by running the GET method without body. Requests for
+// OPTIONS are answered with the implemented methods in the Allow header if the
+// handler implements any method but OPTIONS.
 type MethodHandler struct {
-	handler http.Handler
+	mu          sync.RWMutex
+	handler     http.Handler
+	strict      bool
+	renderError ErrorRenderer
+	methods     map[string]http.HandlerFunc
+	extensions  []string
+	dispatch    map[string]http.HandlerFunc
+	allow       string
 }
 
 // NewMethodHandler returns a new method handler.
 func NewMethodHandler(h http.Handler) *MethodHandler {
-	return &MethodHandler{
-		handler: h,
+	return NewMethodHandlerWithConfig(h, nil)
+}
+
+// NewMethodHandlerWithConfig returns a new method handler controlled
+// by the given configuration.
+func NewMethodHandlerWithConfig(h http.Handler, config *MethodHandlerConfig) *MethodHandler {
+	mh := &MethodHandler{
+		handler:     h,
+		renderError: RenderError,
+		methods:     make(map[string]http.HandlerFunc),
+	}
+	if config != nil {
+		mh.strict = config.Strict
+		if config.ErrorRenderer != nil {
+			mh.renderError = config.ErrorRenderer
+		}
 	}
+	mh.analyze()
+	mh.update()
+	return mh
+}
+
+// HandleFunc registers the handler function for the given HTTP method. This
+// way extension methods like PROPFIND, MKCOL, LOCK, REPORT, or QUERY can be
+// served. Standard methods are overwritten.
+func (h *MethodHandler) HandleFunc(method string, hf http.HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.methods[method]; !ok && !isStandardMethod(method) {
+		h.extensions = append(h.extensions, method)
+	}
+	h.methods[method] = hf
+	h.update()
+}
+
+// HandleNamed registers the named method of the wrapped handler for the given
+// HTTP method. The method must have the signature of http.HandlerFunc or of the
+// error returning variant. An empty name defaults to ServeHTTP<Method>, e.g.
+// ServeHTTPPropfind() for PROPFIND.
+func (h *MethodHandler) HandleNamed(method, name string) error {
+	if method == "" {
+		return fmt.Errorf("MethodHandler: empty method")
+	}
+	if name == "" {
+		name = "ServeHTTP" + method[:1] + strings.ToLower(method[1:])
+	}
+	m := reflect.ValueOf(h.handler).MethodByName(name)
+	if !m.IsValid() {
+		return fmt.Errorf("MethodHandler: handler has no method %q", name)
+	}
+	switch f := m.Interface().(type) {
+	case func(http.ResponseWriter, *http.Request):
+		h.HandleFunc(method, f)
+	case func(http.ResponseWriter, *http.Request) error:
+		h.HandleFunc(method, h.handleError(f))
+	default:
+		return fmt.Errorf("MethodHandler: method %q has no handler signature", name)
+	}
+	return nil
+}
+
+// Methods returns the HTTP methods implemented by the wrapped handler followed
+// by the registered extension methods. HEAD and OPTIONS are included when
+// synthesized by the method handler.
+func (h *MethodHandler) Methods() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.methodList()
+}
+
+// Allows checks if the method handler serves the method. In strict mode
+// these are only the implemented and synthesized methods.
+func (h *MethodHandler) Allows(method string) bool {
+	if !h.strict {
+		return true
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.dispatch[method]
+	return ok
 }
 
 // ServeHTTP implements the http.Handler interface. If the wrapped handler implements
 // the matching interface for the HTTP request method the according ServeHTTP<method>()
-// method will be called. Other it simply calls the default ServeHTTP() method.
+// method will be called. Otherwise it simply calls the default ServeHTTP() method or,
+// in strict mode, answers with a 405 and an Allow header.
 func (h *MethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		if hh, ok := h.handler.(GetHandler); ok {
-			hh.ServeHTTPGet(w, r)
-			return
-		}
-	case http.MethodPost:
-		if hh, ok := h.handler.(PostHandler); ok {
-			hh.ServeHTTPPost(w, r)
-			return
-		}
-	case http.MethodPut:
-		if hh, ok := h.handler.(PutHandler); ok {
-			hh.ServeHTTPPut(w, r)
-			return
-		}
-	case http.MethodDelete:
-		if hh, ok := h.handler.(DeleteHandler); ok {
-			hh.ServeHTTPDelete(w, r)
-			return
-		}
-	case http.MethodHead:
-		if hh, ok := h.handler.(HeadHandler); ok {
-			hh.ServeHTTPHead(w, r)
-			return
-		}
-	case http.MethodPatch:
-		if hh, ok := h.handler.(PatchHandler); ok {
-			hh.ServeHTTPPatch(w, r)
-			return
+	h.mu.RLock()
+	hf, ok := h.dispatch[r.Method]
+	allow := h.allow
+	h.mu.RUnlock()
+
+	if ok {
+		hf(w, r)
+		return
+	}
+	if h.strict {
+		w.Header().Set(HeaderAllow, allow)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// Fall back to default for no matching handler method or any
+	// other HTTP method.
+	h.handler.ServeHTTP(w, r)
+}
+
+// analyze checks which of the method handler interfaces are implemented
+// by the wrapped handler and collects the according methods. The plain
+// interfaces have precedence over the error returning ones.
+func (h *MethodHandler) analyze() {
+	if hh, ok := h.handler.(GetHandler); ok {
+		h.methods[http.MethodGet] = hh.ServeHTTPGet
+	} else if hh, ok := h.handler.(GetHandlerE); ok {
+		h.methods[http.MethodGet] = h.handleError(hh.ServeHTTPGetE)
+	}
+	if hh, ok := h.handler.(HeadHandler); ok {
+		h.methods[http.MethodHead] = hh.ServeHTTPHead
+	} else if hh, ok := h.handler.(HeadHandlerE); ok {
+		h.methods[http.MethodHead] = h.handleError(hh.ServeHTTPHeadE)
+	}
+	if hh, ok := h.handler.(PostHandler); ok {
+		h.methods[http.MethodPost] = hh.ServeHTTPPost
+	} else if hh, ok := h.handler.(PostHandlerE); ok {
+		h.methods[http.MethodPost] = h.handleError(hh.ServeHTTPPostE)
+	}
+	if hh, ok := h.handler.(PutHandler); ok {
+		h.methods[http.MethodPut] = hh.ServeHTTPPut
+	} else if hh, ok := h.handler.(PutHandlerE); ok {
+		h.methods[http.MethodPut] = h.handleError(hh.ServeHTTPPutE)
+	}
+	if hh, ok := h.handler.(PatchHandler); ok {
+		h.methods[http.MethodPatch] = hh.ServeHTTPPatch
+	} else if hh, ok := h.handler.(PatchHandlerE); ok {
+		h.methods[http.MethodPatch] = h.handleError(hh.ServeHTTPPatchE)
+	}
+	if hh, ok := h.handler.(DeleteHandler); ok {
+		h.methods[http.MethodDelete] = hh.ServeHTTPDelete
+	} else if hh, ok := h.handler.(DeleteHandlerE); ok {
+		h.methods[http.MethodDelete] = h.handleError(hh.ServeHTTPDeleteE)
+	}
+	if hh, ok := h.handler.(ConnectHandler); ok {
+		h.methods[http.MethodConnect] = hh.ServeHTTPConnect
+	} else if hh, ok := h.handler.(ConnectHandlerE); ok {
+		h.methods[http.MethodConnect] = h.handleError(hh.ServeHTTPConnectE)
+	}
+	if hh, ok := h.handler.(OptionsHandler); ok {
+		h.methods[http.MethodOptions] = hh.ServeHTTPOptions
+	} else if hh, ok := h.handler.(OptionsHandlerE); ok {
+		h.methods[http.MethodOptions] = h.handleError(hh.ServeHTTPOptionsE)
+	}
+	if hh, ok := h.handler.(TraceHandler); ok {
+		h.methods[http.MethodTrace] = hh.ServeHTTPTrace
+	} else if hh, ok := h.handler.(TraceHandlerE); ok {
+		h.methods[http.MethodTrace] = h.handleError(hh.ServeHTTPTraceE)
+	}
+}
+
+// update creates the dispatching table out of the implemented and registered
+// methods. Here HEAD and OPTIONS are synthesized if not implemented.
+func (h *MethodHandler) update() {
+	h.dispatch = make(map[string]http.HandlerFunc, len(h.methods)+2)
+	for method, hf := range h.methods {
+		h.dispatch[method] = hf
+	}
+	if get, ok := h.dispatch[http.MethodGet]; ok {
+		if _, ok := h.dispatch[http.MethodHead]; !ok {
+			h.dispatch[http.MethodHead] = func(w http.ResponseWriter, r *http.Request) {
+				h.serveHead(get, w, r)
+			}
 		}
-	case http.MethodConnect:
-		if hh, ok := h.handler.(ConnectHandler); ok {
-			hh.ServeHTTPConnect(w, r)
-			return
+	}
+	if _, ok := h.dispatch[http.MethodOptions]; !ok && len(h.dispatch) > 0 {
+		h.dispatch[http.MethodOptions] = h.serveOptions
+	}
+	h.allow = strings.Join(h.methodList(), ", ")
+}
+
+// methodList returns the dispatched methods in the standard order
+// followed by the extension methods.
+func (h *MethodHandler) methodList() []string {
+	var methods []string
+	for _, method := range standardMethods {
+		if _, ok := h.dispatch[method]; ok {
+			methods = append(methods, method)
 		}
-	case http.MethodOptions:
-		if hh, ok := h.handler.(OptionsHandler); ok {
-			hh.ServeHTTPOptions(w, r)
-			return
+	}
+	return append(methods, h.extensions...)
+}
+
+// handleError returns a handler function calling the error returning
+// method and rendering a returned error.
+func (h *MethodHandler) handleError(hfe func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := hfe(w, r); err != nil {
+			h.renderError(w, r, err)
 		}
-	case http.MethodTrace:
-		if hh, ok := h.handler.(TraceHandler); ok {
-			hh.ServeHTTPTrace(w, r)
-			return
+	}
+}
+
+// serveHead answers a HEAD request by running the GET method with
+// the body discarded but the Content-Length kept.
+func (h *MethodHandler) serveHead(get http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	hw := &headResponseWriter{
+		rw:         w,
+		statusCode: http.StatusOK,
+	}
+	get(hw, r)
+	if w.Header().Get(HeaderContentLength) == "" && hw.written > 0 {
+		w.Header().Set(HeaderContentLength, strconv.FormatInt(hw.written, 10))
+	}
+	w.WriteHeader(hw.statusCode)
+}
+
+// serveOptions answers an OPTIONS request with the implemented methods
+// in the Allow header.
+func (h *MethodHandler) serveOptions(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	allow := h.allow
+	h.mu.RUnlock()
+
+	w.Header().Set(HeaderAllow, allow)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isStandardMethod checks if the method is one of the standard ones.
+func isStandardMethod(method string) bool {
+	for _, sm := range standardMethods {
+		if sm == method {
+			return true
 		}
 	}
-	// Fall back to default for no matching handler method or any
-	// other HTTP method.
-	h.handler.ServeHTTP(w, r)
+	return false
+}
+
+//--------------------
+// HEAD RESPONSE WRITER
+//--------------------
+
+// headResponseWriter is used when synthesizing HEAD requests. It
+// delays the writing of the status code and counts and discards
+// the written body.
+type headResponseWriter struct {
+	rw          http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	written     int64
+}
+
+// Header implements http.ResponseWriter.
+func (hw *headResponseWriter) Header() http.Header {
+	return hw.rw.Header()
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (hw *headResponseWriter) WriteHeader(statusCode int) {
+	if hw.wroteHeader {
+		return
+	}
+	hw.statusCode = statusCode
+	hw.wroteHeader = true
+}
+
+// Write implements http.ResponseWriter.
+func (hw *headResponseWriter) Write(b []byte) (int, error) {
+	hw.WriteHeader(http.StatusOK)
+	hw.written += int64(len(b))
+	return len(b), nil
 }
 
 // EOF
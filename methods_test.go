@@ -12,6 +12,7 @@ package httpx_test // import "tideland.dev/go/httpx"
 //--------------------
 
 import (
+	"errors"
 	"net/http"
 	"testing"
 
@@ -65,8 +66,8 @@ func TestMethodHandler(t *testing.T) {
 			body:       "bad request",
 		}, {
 			method:     http.MethodOptions,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusNoContent,
+			body:       "",
 		}, {
 			method:     http.MethodTrace,
 			statusCode: http.StatusBadRequest,
@@ -85,6 +86,229 @@ func TestMethodHandler(t *testing.T) {
 	}
 }
 
+// TestStrictMethodHandler tests the rejection of not implemented
+// methods by a strict MethodHandler.
+func TestStrictMethodHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	mh := httpx.NewMethodHandlerWithConfig(metaHandler{}, &httpx.MethodHandlerConfig{
+		Strict: true,
+	})
+	s := web.NewSimulator(mh)
+
+	assert.Equal(mh.Methods(), []string{http.MethodPut, http.MethodDelete, http.MethodOptions})
+
+	tests := []struct {
+		method     string
+		statusCode int
+		allow      string
+	}{
+		{
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			allow:      "PUT, DELETE, OPTIONS",
+		}, {
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			allow:      "PUT, DELETE, OPTIONS",
+		}, {
+			method:     http.MethodPut,
+			statusCode: http.StatusOK,
+		}, {
+			method:     http.MethodDelete,
+			statusCode: http.StatusNoContent,
+		}, {
+			method:     "PROPFIND",
+			statusCode: http.StatusMethodNotAllowed,
+			allow:      "PUT, DELETE, OPTIONS",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.method)
+		req := s.CreateRequest(test.method, "/", nil)
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		assert.Equal(resp.Header.Get(httpx.HeaderAllow), test.allow)
+	}
+}
+
+// TestMethodHandlerSynthesis tests the automatic answering of HEAD
+// and OPTIONS requests by the MethodHandler.
+func TestMethodHandlerSynthesis(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	mh := httpx.NewMethodHandler(getHandler{})
+	s := web.NewSimulator(mh)
+
+	assert.Equal(mh.Methods(), []string{http.MethodGet, http.MethodHead, http.MethodOptions})
+
+	// GET returns the body.
+	resp, err := s.Get("/")
+	assert.NoError(err)
+	assert.Equal(resp.StatusCode, http.StatusAccepted)
+	body, err := web.BodyToString(resp)
+	assert.NoError(err)
+	assert.Equal(body, "METHOD: GET!")
+
+	// HEAD keeps status code and length but drops the body.
+	req := s.CreateRequest(http.MethodHead, "/", nil)
+	resp, err = s.Do(req)
+	assert.NoError(err)
+	assert.Equal(resp.StatusCode, http.StatusAccepted)
+	assert.Equal(resp.Header.Get(httpx.HeaderContentLength), "13")
+	assert.Equal(resp.Header.Get(httpx.HeaderContentType), httpx.ContentTypePlain)
+	body, err = web.BodyToString(resp)
+	assert.NoError(err)
+	assert.Equal(body, "")
+
+	// OPTIONS lists the methods.
+	req = s.CreateRequest(http.MethodOptions, "/", nil)
+	resp, err = s.Do(req)
+	assert.NoError(err)
+	assert.Equal(resp.StatusCode, http.StatusNoContent)
+	assert.Equal(resp.Header.Get(httpx.HeaderAllow), "GET, HEAD, OPTIONS")
+}
+
+// TestMethodHandlerErrors tests the dispatching to error returning
+// handler methods and the rendering of the errors.
+func TestMethodHandlerErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	s := web.NewSimulator(httpx.NewMethodHandler(errorHandler{}))
+
+	tests := []struct {
+		method      string
+		accept      string
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		{
+			method:      http.MethodGet,
+			statusCode:  http.StatusOK,
+			contentType: httpx.ContentTypePlain,
+			body:        "METHOD: GET!",
+		}, {
+			method:      http.MethodPost,
+			statusCode:  http.StatusConflict,
+			contentType: httpx.ContentTypePlain,
+			body:        "cannot post: conflict",
+		}, {
+			method:      http.MethodPost,
+			accept:      httpx.ContentTypeJSON,
+			statusCode:  http.StatusConflict,
+			contentType: httpx.ContentTypeJSON,
+			body:        `{"statusCode":409,"message":"cannot post: conflict"}`,
+		}, {
+			method:      http.MethodPost,
+			accept:      httpx.ContentTypeXML,
+			statusCode:  http.StatusConflict,
+			contentType: httpx.ContentTypeXML,
+			body:        `<error><statusCode>409</statusCode><message>cannot post: conflict</message></error>`,
+		}, {
+			method:      http.MethodDelete,
+			statusCode:  http.StatusInternalServerError,
+			contentType: httpx.ContentTypePlain,
+			body:        "cannot delete",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s %s", i, test.method, test.accept)
+		req := s.CreateRequest(test.method, "/", nil)
+		if test.accept != "" {
+			req.Header.Set(httpx.HeaderAccept, test.accept)
+		}
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		assert.Contains(test.contentType, resp.Header.Get(httpx.HeaderContentType))
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Contains(test.body, body)
+	}
+}
+
+// TestMethodHandlerErrorRenderer tests the usage of a configured
+// error renderer.
+func TestMethodHandlerErrorRenderer(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	mh := httpx.NewMethodHandlerWithConfig(errorHandler{}, &httpx.MethodHandlerConfig{
+		ErrorRenderer: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "custom: "+err.Error(), http.StatusTeapot)
+		},
+	})
+	s := web.NewSimulator(mh)
+
+	req := s.CreateRequest(http.MethodPost, "/", nil)
+	resp, err := s.Do(req)
+	assert.NoError(err)
+	assert.Equal(resp.StatusCode, http.StatusTeapot)
+	body, err := web.BodyToString(resp)
+	assert.NoError(err)
+	assert.Equal(body, "custom: cannot post: conflict\n")
+}
+
+// TestMethodHandlerExtensions tests the registration of extension
+// methods at the MethodHandler.
+func TestMethodHandlerExtensions(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	mh := httpx.NewMethodHandlerWithConfig(davHandler{}, &httpx.MethodHandlerConfig{
+		Strict: true,
+	})
+	assert.NoError(mh.HandleNamed("PROPFIND", ""))
+	assert.NoError(mh.HandleNamed("MKCOL", "MakeCollection"))
+	assert.ErrorContains(mh.HandleNamed("LOCK", ""), `handler has no method "ServeHTTPLock"`)
+	assert.ErrorContains(mh.HandleNamed("LOCK", "Invalid"), `method "Invalid" has no handler signature`)
+	mh.HandleFunc("QUERY", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	s := web.NewSimulator(mh)
+
+	assert.Equal(mh.Methods(), []string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "MKCOL", "QUERY"})
+
+	tests := []struct {
+		method     string
+		statusCode int
+		body       string
+	}{
+		{
+			method:     http.MethodGet,
+			statusCode: http.StatusAccepted,
+			body:       "METHOD: GET!",
+		}, {
+			method:     "PROPFIND",
+			statusCode: http.StatusMultiStatus,
+			body:       "METHOD: PROPFIND!",
+		}, {
+			method:     "MKCOL",
+			statusCode: http.StatusConflict,
+			body:       "collection exists",
+		}, {
+			method:     "QUERY",
+			statusCode: http.StatusAccepted,
+			body:       "",
+		}, {
+			method:     "LOCK",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       "Method Not Allowed",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.method)
+		req := s.CreateRequest(test.method, "/", nil)
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Contains(test.body, body)
+	}
+
+	// OPTIONS lists the extensions too.
+	req := s.CreateRequest(http.MethodOptions, "/", nil)
+	resp, err := s.Do(req)
+	assert.NoError(err)
+	assert.Equal(resp.Header.Get(httpx.HeaderAllow), "GET, HEAD, OPTIONS, PROPFIND, MKCOL, QUERY")
+}
+
 //--------------------
 // HELPING META HANDLER
 //--------------------
@@ -111,4 +335,64 @@ func (h metaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "bad request", http.StatusBadRequest)
 }
 
+// getHandler only provides the GET method for the MethodHandler.
+type getHandler struct{}
+
+func (h getHandler) ServeHTTPGet(w http.ResponseWriter, r *http.Request) {
+	reply := "METHOD: " + r.Method + "!"
+	w.Header().Add(httpx.HeaderContentType, httpx.ContentTypePlain)
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := w.Write([]byte(reply)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h getHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "bad request", http.StatusBadRequest)
+}
+
+// errorHandler provides error returning methods for the MethodHandler.
+type errorHandler struct{}
+
+func (h errorHandler) ServeHTTPGetE(w http.ResponseWriter, r *http.Request) error {
+	reply := "METHOD: " + r.Method + "!"
+	w.Header().Add(httpx.HeaderContentType, httpx.ContentTypePlain)
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(reply))
+	return err
+}
+
+func (h errorHandler) ServeHTTPPostE(w http.ResponseWriter, r *http.Request) error {
+	return httpx.StatusErrorf(http.StatusConflict, "cannot post: %s", "conflict")
+}
+
+func (h errorHandler) ServeHTTPDeleteE(w http.ResponseWriter, r *http.Request) error {
+	return errors.New("cannot delete")
+}
+
+func (h errorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "bad request", http.StatusBadRequest)
+}
+
+// davHandler provides some WebDAV methods for the MethodHandler.
+type davHandler struct {
+	getHandler
+}
+
+func (h davHandler) ServeHTTPPropfind(w http.ResponseWriter, r *http.Request) {
+	reply := "METHOD: " + r.Method + "!"
+	w.WriteHeader(http.StatusMultiStatus)
+	if _, err := w.Write([]byte(reply)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h davHandler) MakeCollection(w http.ResponseWriter, r *http.Request) error {
+	return httpx.StatusErrorf(http.StatusConflict, "collection exists")
+}
+
+func (h davHandler) Invalid(w http.ResponseWriter) {}
+
 // EOF
@@ -0,0 +1,254 @@
+// Tideland Go HTTP Extensions - Middleware
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package middleware // import "tideland.dev/go/httpx/middleware"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	HeaderAcceptEncoding  = "Accept-Encoding"
+	HeaderContentEncoding = "Content-Encoding"
+	HeaderContentLength   = "Content-Length"
+
+	// supportedEncodings is the list of supported content encodings
+	// sent in case of an unsupported one.
+	supportedEncodings = "gzip, deflate, zstd"
+)
+
+//--------------------
+// DECOMPRESS HANDLER
+//--------------------
+
+// DecompressHandlerConfig allows to control how the decompress handler works.
+// Default values are:
+//  - MaxSize: 10 MB
+type DecompressHandlerConfig struct {
+	// MaxSize limits the size of the decompressed body in bytes.
+	MaxSize int64
+}
+
+// DecompressHandler transparently decompresses request bodies encoded with
+// gzip, deflate, or zstd. The size of the decompressed body is limited, reading
+// beyond it fails with an error answering with 413 Request Entity Too Large.
+// Unsupported encodings are answered with 415 Unsupported Media Type.
+type DecompressHandler struct {
+	handler http.Handler
+	maxSize int64
+}
+
+// NewDecompressHandler creates a new handler decompressing request bodies.
+func NewDecompressHandler(handler http.Handler, config *DecompressHandlerConfig) *DecompressHandler {
+	if config == nil {
+		config = &DecompressHandlerConfig{}
+	}
+	h := &DecompressHandler{
+		handler: handler,
+		maxSize: config.MaxSize,
+	}
+	if h.maxSize <= 0 {
+		h.maxSize = 10 << 20
+	}
+	return h
+}
+
+// WrapDecompress returns a wrapper using the decompress handler.
+func WrapDecompress(config *DecompressHandlerConfig) Wrapper {
+	return func(handler http.Handler) http.Handler {
+		return NewDecompressHandler(handler, config)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *DecompressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	encodings := contentEncodings(r)
+	if len(encodings) == 0 {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	for _, encoding := range encodings {
+		if !isSupportedEncoding(encoding) {
+			w.Header().Set(HeaderAcceptEncoding, supportedEncodings)
+			msg := fmt.Sprintf("DecompressHandler: unsupported content encoding %q", encoding)
+			http.Error(w, msg, http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	body, err := newDecompressReader(r.Body, encodings, h.maxSize)
+	if err != nil {
+		_ = r.Body.Close()
+		msg := fmt.Sprintf("DecompressHandler: cannot decompress body: %v", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	dr := new(http.Request)
+	*dr = *r
+	dr.Header = r.Header.Clone()
+	dr.Header.Del(HeaderContentEncoding)
+	dr.Header.Del(HeaderContentLength)
+	dr.ContentLength = -1
+	dr.Body = body
+	h.handler.ServeHTTP(w, dr)
+}
+
+// contentEncodings returns the content encodings of the request in the
+// order they have been applied. The identity is skipped.
+func contentEncodings(r *http.Request) []string {
+	var encodings []string
+	for _, value := range r.Header.Values(HeaderContentEncoding) {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" || encoding == "identity" {
+				continue
+			}
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// isSupportedEncoding checks if the encoding can be decompressed.
+func isSupportedEncoding(encoding string) bool {
+	switch encoding {
+	case "gzip", "x-gzip", "deflate", "zstd":
+		return true
+	}
+	return false
+}
+
+//--------------------
+// DECOMPRESS READER
+//--------------------
+
+// decompressReader reads the decompressed body and limits its size.
+type decompressReader struct {
+	reader  io.Reader
+	closers []io.Closer
+	maxSize int64
+	remains int64
+}
+
+// newDecompressReader creates the chain of decompressors undoing the encodings
+// in reverse order.
+func newDecompressReader(body io.ReadCloser, encodings []string, maxSize int64) (*decompressReader, error) {
+	dr := &decompressReader{
+		reader:  body,
+		closers: []io.Closer{body},
+		maxSize: maxSize,
+		remains: maxSize,
+	}
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var rc io.ReadCloser
+		var err error
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			rc, err = gzip.NewReader(dr.reader)
+		case "deflate":
+			rc, err = newDeflateReader(dr.reader)
+		case "zstd":
+			var dec *zstd.Decoder
+			dec, err = zstd.NewReader(dr.reader, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(zstdMaxMemory(maxSize)))
+			if err == nil {
+				rc = dec.IOReadCloser()
+			}
+		}
+		if err != nil {
+			_ = dr.Close()
+			return nil, fmt.Errorf("invalid %s encoding: %v", encodings[i], err)
+		}
+		dr.reader = rc
+		dr.closers = append(dr.closers, rc)
+	}
+	return dr, nil
+}
+
+// newDeflateReader returns a reader for deflate encoded data. It's zlib
+// format, but some clients send raw deflate data.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// zstdMaxMemory returns the memory the zstd decoder may use. It's the
+// maximum size, but at least the default window of zstd encoders.
+func zstdMaxMemory(maxSize int64) uint64 {
+	if maxSize < 8<<20 {
+		return 8 << 20
+	}
+	return uint64(maxSize)
+}
+
+// Read implements io.Reader.
+func (dr *decompressReader) Read(p []byte) (int, error) {
+	if dr.remains <= 0 {
+		// Check if there's more than allowed.
+		var probe [1]byte
+		n, err := dr.reader.Read(probe[:])
+		if n > 0 {
+			return 0, dr.tooLarge()
+		}
+		return 0, err
+	}
+	if int64(len(p)) > dr.remains {
+		p = p[:dr.remains]
+	}
+	n, err := dr.reader.Read(p)
+	dr.remains -= int64(n)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return n, dr.tooLarge()
+	}
+	return n, err
+}
+
+// tooLarge returns the error for a decompressed body exceeding the
+// maximum size.
+func (dr *decompressReader) tooLarge() error {
+	return httpx.StatusErrorf(http.StatusRequestEntityTooLarge,
+		"DecompressHandler: decompressed body exceeds limit of %d bytes", dr.maxSize)
+}
+
+// Close implements io.Closer. It closes the decompressors and the body.
+func (dr *decompressReader) Close() error {
+	var first error
+	for i := len(dr.closers) - 1; i >= 0; i-- {
+		if err := dr.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	dr.closers = nil
+	return first
+}
+
+// EOF
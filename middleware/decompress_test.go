@@ -0,0 +1,182 @@
+// Tideland Go HTTP Extensions - Middleware - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package middleware_test // import "tideland.dev/go/httpx/middleware"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/web"
+
+	"tideland.dev/go/httpx"
+	"tideland.dev/go/httpx/middleware"
+)
+
+//--------------------
+// TESTING
+//--------------------
+
+// TestDecompress verifies the decompression of request bodies.
+func TestDecompress(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	testhandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var text string
+		if err := httpx.ReadBody(r, &text); err != nil {
+			http.Error(w, err.Error(), httpx.ErrorStatusCode(err))
+			return
+		}
+		w.Header().Set(httpx.HeaderContentType, httpx.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(r.Header.Get(middleware.HeaderContentEncoding) + "|" + text))
+		assert.NoError(err)
+	})
+	handler := middleware.Wrap(testhandler, middleware.WrapDecompress(&middleware.DecompressHandlerConfig{
+		MaxSize: 1024,
+	}))
+	s := web.NewSimulator(handler)
+	plain := "Hello, compressed World!"
+	large := strings.Repeat("x", 2048)
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		statusCode int
+		reply      string
+	}{
+		{
+			name:       "no encoding",
+			body:       []byte(plain),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "identity",
+			encoding:   "identity",
+			body:       []byte(plain),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "gzip",
+			encoding:   "gzip",
+			body:       compress(assert, plain, "gzip"),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "x-gzip",
+			encoding:   "X-GZIP",
+			body:       compress(assert, plain, "gzip"),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "deflate",
+			encoding:   "deflate",
+			body:       compress(assert, plain, "deflate"),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "raw deflate",
+			encoding:   "deflate",
+			body:       compress(assert, plain, "raw"),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "zstd",
+			encoding:   "zstd",
+			body:       compress(assert, plain, "zstd"),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "chained",
+			encoding:   "deflate, zstd",
+			body:       compress(assert, string(compress(assert, plain, "deflate")), "zstd"),
+			statusCode: http.StatusOK,
+			reply:      "|" + plain,
+		}, {
+			name:       "unsupported",
+			encoding:   "br",
+			body:       []byte(plain),
+			statusCode: http.StatusUnsupportedMediaType,
+			reply:      `DecompressHandler: unsupported content encoding "br"`,
+		}, {
+			name:       "invalid gzip",
+			encoding:   "gzip",
+			body:       []byte(plain),
+			statusCode: http.StatusBadRequest,
+			reply:      "DecompressHandler: cannot decompress body: invalid gzip encoding",
+		}, {
+			name:       "too large gzip",
+			encoding:   "gzip",
+			body:       compress(assert, large, "gzip"),
+			statusCode: http.StatusRequestEntityTooLarge,
+			reply:      "decompressed body exceeds limit of 1024 bytes",
+		}, {
+			name:       "too large zstd",
+			encoding:   "zstd",
+			body:       compress(assert, large, "zstd"),
+			statusCode: http.StatusRequestEntityTooLarge,
+			reply:      "decompressed body exceeds limit of 1024 bytes",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := s.CreateRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+		req.Header.Set(httpx.HeaderContentType, httpx.ContentTypePlain)
+		if test.encoding != "" {
+			req.Header.Set(middleware.HeaderContentEncoding, test.encoding)
+		}
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		if test.statusCode == http.StatusUnsupportedMediaType {
+			assert.Equal(resp.Header.Get(middleware.HeaderAcceptEncoding), "gzip, deflate, zstd")
+		}
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Contains(test.reply, body)
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// compress encodes the content with the given algorithm.
+func compress(assert *asserts.Asserts, content, algorithm string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch algorithm {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	}
+	assert.NoError(err)
+	_, err = w.Write([]byte(content))
+	assert.NoError(err)
+	assert.NoError(w.Close())
+	return buf.Bytes()
+}
+
+// EOF
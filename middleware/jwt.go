@@ -12,6 +12,7 @@ package middleware // import "tideland.dev/go/httpx/middleware"
 //--------------------
 
 import (
+	"encoding/xml"
 	"log"
 	"net/http"
 	"strconv"
@@ -139,16 +140,29 @@ func (h *JWTHandler) isAuthorized(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
-// deny sends a negative feedback to the caller.
+// jwtFeedback is the body of a denial.
+type jwtFeedback struct {
+	XMLName    xml.Name `json:"-" xml:"error"`
+	StatusCode string   `json:"statusCode" xml:"statusCode"`
+	Message    string   `json:"message" xml:"message"`
+}
+
+// deny sends a negative feedback to the caller. The content type is
+// negotiated, default is JSON.
 func (h *JWTHandler) deny(w http.ResponseWriter, r *http.Request, msg string, statusCode int) {
-	feedback := map[string]string{
-		"statusCode": strconv.Itoa(statusCode),
-		"message":    msg,
+	feedback := jwtFeedback{
+		StatusCode: strconv.Itoa(statusCode),
+		Message:    msg,
 	}
-	accept := r.Header.Get(httpx.HeaderAccept)
+	contentType := httpx.Negotiate(r, httpx.ContentTypeJSON, httpx.ContentTypeXML)
+	if contentType == "" {
+		contentType = httpx.ContentTypeJSON
+	}
+	codec, _ := httpx.LookupCodec(contentType)
+	w.Header().Add(httpx.HeaderVary, httpx.HeaderAccept)
+	w.Header().Set(httpx.HeaderContentType, contentType)
 	w.WriteHeader(statusCode)
-	_, err := httpx.WriteBody(w, accept, feedback)
-	if err != nil {
+	if err := codec.Encode(w, feedback); err != nil {
 		h.logger.Printf("JWT handler: %v", err)
 	}
 }
@@ -100,10 +100,64 @@ func TestJWTHandler(t *testing.T) {
 		resp, err := s.Do(req)
 		assert.NoError(err)
 		assert.Equal(resp.StatusCode, test.statusCode)
+		if test.statusCode != http.StatusOK {
+			assert.Equal(resp.Header.Get(httpx.HeaderContentType), httpx.ContentTypeJSON)
+		}
 		body, err := web.BodyToString(resp)
 		assert.NoError(err)
 		assert.Contains(test.body, body)
 	}
 }
 
+// TestJWTHandlerNegotiation tests the negotiation of the content type
+// of denials.
+func TestJWTHandlerNegotiation(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	testhandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := middleware.NewJWTHandler(testhandler, &middleware.JWTHandlerConfig{
+		Key: []byte("secret"),
+	})
+	s := web.NewSimulator(handler)
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			accept:      "",
+			contentType: httpx.ContentTypeJSON,
+			body:        `{"statusCode":"401","message":"request contains no authorization header"}`,
+		}, {
+			accept:      "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			contentType: httpx.ContentTypeXML,
+			body:        `<error><statusCode>401</statusCode><message>request contains no authorization header</message></error>`,
+		}, {
+			accept:      "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8",
+			contentType: httpx.ContentTypeJSON,
+			body:        `{"statusCode":"401","message":"request contains no authorization header"}`,
+		}, {
+			accept:      "text/plain",
+			contentType: httpx.ContentTypeJSON,
+			body:        `{"statusCode":"401","message":"request contains no authorization header"}`,
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.accept)
+		req := s.CreateRequest(http.MethodGet, "/", nil)
+		if test.accept != "" {
+			req.Header.Set(httpx.HeaderAccept, test.accept)
+		}
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, http.StatusUnauthorized)
+		assert.Equal(resp.Header.Get(httpx.HeaderContentType), test.contentType)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+}
+
 // EOF
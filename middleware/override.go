@@ -0,0 +1,145 @@
+// Tideland Go HTTP Extension - Middleware
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package middleware // import "tideland.dev/go/httpx/middleware"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	HeaderMethodOverride = "X-HTTP-Method-Override"
+	FormMethodOverride   = "_method"
+
+	// maxOverrideFormSize is the maximum size of a form body read
+	// for the method override.
+	maxOverrideFormSize = 10 << 20
+)
+
+//--------------------
+// METHOD OVERRIDE
+//--------------------
+
+// MethodOverrideHandler allows clients only able to send POST requests to
+// tunnel other methods. Those are taken from the X-HTTP-Method-Override
+// header or the _method field of a form and checked against a list of
+// allowed methods. The form is parsed from a buffered copy of the request
+// body, so handlers still can read it. Larger forms than 10 MB are not
+// checked for the field.
+type MethodOverrideHandler struct {
+	handler http.Handler
+	allowed map[string]bool
+}
+
+// NewMethodOverrideHandler creates a new handler overriding the method of
+// POST requests with one of the allowed methods. Without any given method
+// PUT, PATCH, and DELETE are allowed.
+func NewMethodOverrideHandler(handler http.Handler, allowed ...string) *MethodOverrideHandler {
+	if len(allowed) == 0 {
+		allowed = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	h := &MethodOverrideHandler{
+		handler: handler,
+		allowed: make(map[string]bool, len(allowed)),
+	}
+	for _, method := range allowed {
+		h.allowed[strings.ToUpper(method)] = true
+	}
+	return h
+}
+
+// WrapMethodOverride returns a wrapper using the method override handler.
+func WrapMethodOverride(allowed ...string) Wrapper {
+	return func(handler http.Handler) http.Handler {
+		return NewMethodOverrideHandler(handler, allowed...)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *MethodOverrideHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	override := r.Header.Get(HeaderMethodOverride)
+	if override == "" && isForm(r) {
+		override = formMethodOverride(r)
+	}
+	method := strings.ToUpper(strings.TrimSpace(override))
+	if method == "" || method == http.MethodPost {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	if !h.allowed[method] {
+		msg := fmt.Sprintf("MethodOverrideHandler: override of %s %s with %s is not allowed", r.Method, r.URL.Path, method)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	or := new(http.Request)
+	*or = *r
+	or.Method = method
+	h.handler.ServeHTTP(w, or)
+}
+
+// formMethodOverride reads the method override field of the form in the
+// request body. The body is restored afterwards.
+func formMethodOverride(r *http.Request) string {
+	if r.PostForm != nil {
+		// Already parsed before.
+		return r.PostForm.Get(FormMethodOverride)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxOverrideFormSize+1))
+	r.Body = &restoredBody{
+		Reader: io.MultiReader(bytes.NewReader(data), r.Body),
+		Closer: r.Body,
+	}
+	if err != nil || len(data) > maxOverrideFormSize {
+		return ""
+	}
+	fr := new(http.Request)
+	*fr = *r
+	fr.Body = ioutil.NopCloser(bytes.NewReader(data))
+	fr.ContentLength = int64(len(data))
+	if err := fr.ParseMultipartForm(maxOverrideFormSize); err != nil && err != http.ErrNotMultipart {
+		return ""
+	}
+	if fr.MultipartForm != nil {
+		_ = fr.MultipartForm.RemoveAll()
+	}
+	return fr.PostForm.Get(FormMethodOverride)
+}
+
+// restoredBody is the request body after reading the form.
+type restoredBody struct {
+	io.Reader
+	io.Closer
+}
+
+// isForm checks if the request body contains a form.
+func isForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// EOF
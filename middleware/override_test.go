@@ -0,0 +1,140 @@
+// Tideland Go HTTP Extensions - Middleware - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package middleware_test // import "tideland.dev/go/httpx/middleware"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/web"
+
+	"tideland.dev/go/httpx/middleware"
+)
+
+//--------------------
+// TESTING
+//--------------------
+
+// TestMethodOverride verifies the overriding of POST requests.
+func TestMethodOverride(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	testhandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(err)
+		w.Header().Set("content-type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte("method: " + r.Method + " body: " + string(body)))
+		assert.NoError(err)
+	})
+	overridewrapper := middleware.WrapMethodOverride()
+	handler := middleware.Wrap(testhandler, overridewrapper)
+	s := web.NewSimulator(handler)
+
+	tests := []struct {
+		name        string
+		method      string
+		header      string
+		contentType string
+		body        string
+		statusCode  int
+		reply       string
+	}{
+		{
+			name:       "plain POST",
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			reply:      "method: POST",
+		}, {
+			name:       "header override",
+			method:     http.MethodPost,
+			header:     "delete",
+			statusCode: http.StatusOK,
+			reply:      "method: DELETE",
+		}, {
+			name:        "form override",
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=foo&_method=PUT",
+			statusCode:  http.StatusOK,
+			reply:       "method: PUT body: name=foo&_method=PUT",
+		}, {
+			name:        "multipart form override",
+			method:      http.MethodPost,
+			contentType: multipartContentType,
+			body:        multipartBody(assert, "DELETE"),
+			statusCode:  http.StatusOK,
+			reply:       "method: DELETE body: --" + multipartBoundary,
+		}, {
+			name:        "form without override",
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=foo",
+			statusCode:  http.StatusOK,
+			reply:       "method: POST body: name=foo",
+		}, {
+			name:       "override of GET ignored",
+			method:     http.MethodGet,
+			header:     http.MethodDelete,
+			statusCode: http.StatusOK,
+			reply:      "method: GET",
+		}, {
+			name:       "override not allowed",
+			method:     http.MethodPost,
+			header:     http.MethodTrace,
+			statusCode: http.StatusBadRequest,
+			reply:      "override of POST / with TRACE is not allowed",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := s.CreateRequest(test.method, "/", strings.NewReader(test.body))
+		if test.header != "" {
+			req.Header.Set(middleware.HeaderMethodOverride, test.header)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Contains(test.reply, body)
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+const (
+	multipartBoundary    = "override-boundary"
+	multipartContentType = "multipart/form-data; boundary=" + multipartBoundary
+)
+
+// multipartBody creates a multipart form with the method override field.
+func multipartBody(assert *asserts.Asserts, method string) string {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	assert.NoError(mw.SetBoundary(multipartBoundary))
+	assert.NoError(mw.WriteField("name", "foo"))
+	assert.NoError(mw.WriteField(middleware.FormMethodOverride, method))
+	assert.NoError(mw.Close())
+	return buf.String()
+}
+
+// EOF
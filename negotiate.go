@@ -0,0 +1,204 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//--------------------
+// CONTENT NEGOTIATION
+//--------------------
+
+// Negotiate returns the offered content type best matching the Accept header
+// of the request. Quality values, wildcards like text/* or */*, and media type
+// parameters are taken into account. Offers with the same quality keep their
+// order. Without offers the media types of the registered codecs are used.
+// Without Accept header the first offer is returned, if nothing is acceptable
+// an empty string.
+func Negotiate(r *http.Request, offers ...string) string {
+	if len(offers) == 0 {
+		offers = CodecMediaTypes()
+	}
+	acceptable := acceptableOffers(r, offers)
+	if len(acceptable) == 0 {
+		return ""
+	}
+	return acceptable[0]
+}
+
+// WriteNegotiated writes the value with the codec negotiated based on the
+// offered content types and the Accept header of the request. Without offers
+// the media types of the registered codecs are used. If the value cannot be
+// encoded with the best codec the next acceptable one is tried. If none is
+// acceptable or can encode the value a StatusError with 406 Not Acceptable
+// is returned.
+func WriteNegotiated(w http.ResponseWriter, r *http.Request, value interface{}, offers ...string) (int, error) {
+	if len(offers) == 0 {
+		offers = CodecMediaTypes()
+	}
+	acceptable := acceptableOffers(r, offers)
+	if len(acceptable) == 0 {
+		return 0, StatusErrorf(http.StatusNotAcceptable, "WriteNegotiated: no acceptable content type")
+	}
+	var buf bytes.Buffer
+	var first error
+	for _, contentType := range acceptable {
+		codec, ok := LookupCodec(contentType)
+		if !ok {
+			if first == nil {
+				first = fmt.Errorf("no codec for %q", contentType)
+			}
+			continue
+		}
+		buf.Reset()
+		if err := codec.Encode(&buf, value); err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		w.Header().Add(HeaderVary, HeaderAccept)
+		w.Header().Set(HeaderContentType, contentType)
+		return w.Write(buf.Bytes())
+	}
+	return 0, StatusErrorf(http.StatusNotAcceptable, "WriteNegotiated: cannot marshal value: %v", first)
+}
+
+// acceptableOffers returns the valid offers acceptable by the Accept header
+// ordered by their quality. Offers with the same quality keep their order.
+func acceptableOffers(r *http.Request, offers []string) []string {
+	ranges := parseAccept(strings.Join(r.Header.Values(HeaderAccept), ","))
+	acceptable := make([]string, 0, len(offers))
+	qualities := make([]float64, 0, len(offers))
+	for _, offer := range offers {
+		mediaType, params, err := parseContentType(offer)
+		if err != nil || mediaType == "" {
+			continue
+		}
+		q := 0.0
+		if len(ranges) == 0 {
+			q = 1.0
+		}
+		specificity := -1
+		for _, ar := range ranges {
+			if s, ok := ar.matches(mediaType, params); ok && s > specificity {
+				q = ar.q
+				specificity = s
+			}
+		}
+		if q > 0 {
+			acceptable = append(acceptable, offer)
+			qualities = append(qualities, q)
+		}
+	}
+	sort.Stable(byQuality{acceptable, qualities})
+	return acceptable
+}
+
+// byQuality sorts offers by descending quality.
+type byQuality struct {
+	offers    []string
+	qualities []float64
+}
+
+func (bq byQuality) Len() int           { return len(bq.offers) }
+func (bq byQuality) Less(i, j int) bool { return bq.qualities[i] > bq.qualities[j] }
+func (bq byQuality) Swap(i, j int) {
+	bq.offers[i], bq.offers[j] = bq.offers[j], bq.offers[i]
+	bq.qualities[i], bq.qualities[j] = bq.qualities[j], bq.qualities[i]
+}
+
+//--------------------
+// ACCEPT PARSING
+//--------------------
+
+// acceptRange is one media range of an Accept header.
+type acceptRange struct {
+	mainType string
+	subType  string
+	params   map[string]string
+	q        float64
+}
+
+// parseAccept parses the media ranges of an Accept header. Invalid ranges
+// are skipped.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if part == "*" {
+			part = "*/*"
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		slash := strings.IndexByte(mediaType, '/')
+		if slash < 0 {
+			continue
+		}
+		ar := acceptRange{
+			mainType: mediaType[:slash],
+			subType:  mediaType[slash+1:],
+			params:   params,
+			q:        1.0,
+		}
+		if qv, ok := params["q"]; ok {
+			q, err := strconv.ParseFloat(qv, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+			ar.q = q
+			delete(params, "q")
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// matches checks if the media range matches the media type with its
+// parameters. It returns the specificity of the match.
+func (ar acceptRange) matches(mediaType string, params map[string]string) (int, bool) {
+	slash := strings.IndexByte(mediaType, '/')
+	if slash < 0 {
+		return 0, false
+	}
+	mainType, subType := mediaType[:slash], mediaType[slash+1:]
+	specificity := 0
+	switch {
+	case ar.mainType == "*" && ar.subType == "*":
+	case ar.mainType == mainType && ar.subType == "*":
+		specificity = 1
+	case ar.mainType == mainType && ar.subType == subType:
+		specificity = 2
+	default:
+		return 0, false
+	}
+	for name, value := range ar.params {
+		if !strings.EqualFold(params[name], value) {
+			return 0, false
+		}
+	}
+	return specificity*100 + len(ar.params), true
+}
+
+// EOF
@@ -0,0 +1,120 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestNegotiate tests the negotiation of content types with the Accept header.
+func TestNegotiate(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	tests := []struct {
+		accept   string
+		offers   []string
+		expected string
+	}{
+		{"", []string{"application/json", "application/xml"}, "application/json"},
+		{"application/xml", []string{"application/json", "application/xml"}, "application/xml"},
+		{"application/xml, application/json", []string{"application/json", "application/xml"}, "application/json"},
+		{"application/xml;q=0.5, application/json;q=0.4", []string{"application/json", "application/xml"}, "application/xml"},
+		{browser, []string{"application/json", "application/xml"}, "application/xml"},
+		{browser, []string{"application/json", "text/plain"}, "application/json"},
+		{browser, []string{"text/plain", "text/html"}, "text/html"},
+		{"text/*;q=0.5, */*;q=0.1", []string{"application/json", "text/plain"}, "text/plain"},
+		{"text/*, text/plain;q=0", []string{"text/plain", "text/csv"}, "text/csv"},
+		{"text/plain", []string{"application/json", "application/xml"}, ""},
+		{"application/json;q=0", []string{"application/json"}, ""},
+		{"*", []string{"application/json"}, "application/json"},
+		{"Application/JSON", []string{"application/json"}, "application/json"},
+		{"application/json;version=2", []string{"application/json;version=1", "application/json;version=2"}, "application/json;version=2"},
+		{"application/json;version=3", []string{"application/json;version=1", "application/json;version=2"}, ""},
+		{"application/json;version=2;q=0.5, */*;q=0.1", []string{"application/json", "application/json;version=2"}, "application/json;version=2"},
+		{"application/json;q=abc, application/xml", []string{"application/json", "application/xml"}, "application/xml"},
+		{"no media type", []string{"application/json"}, "application/json"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %q", i, test.accept)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.accept != "" {
+			req.Header.Set(httpx.HeaderAccept, test.accept)
+		}
+		assert.Equal(httpx.Negotiate(req, test.offers...), test.expected)
+	}
+
+	// Multiple header lines and registered codecs.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add(httpx.HeaderAccept, "application/json;q=0.1")
+	req.Header.Add(httpx.HeaderAccept, "text/xml")
+	assert.Equal(httpx.Negotiate(req), "text/xml")
+}
+
+// TestWriteNegotiated tests the writing of negotiated content types.
+func TestWriteNegotiated(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	b := body{FirstString: "valid", SecondInt: 1}
+	m := map[string]int{"one": 1}
+
+	tests := []struct {
+		accept      string
+		value       interface{}
+		offers      []string
+		contentType string
+		body        string
+		err         string
+	}{
+		{"", b, nil, "application/json", `{"first_string":"valid","second_int":1}`, ""},
+		{"application/xml;q=0.9, application/json;q=0.8", b, nil, "application/xml", `<body><fs>valid</fs><si>1</si></body>`, ""},
+		{"text/*", b, nil, "text/xml", `<body><fs>valid</fs><si>1</si></body>`, ""},
+		{"application/xml", b, []string{httpx.ContentTypeJSON, httpx.ContentTypeXML}, "application/xml", `<body><fs>valid</fs><si>1</si></body>`, ""},
+		{"text/*, application/json;q=0.1", m, nil, "application/json", `{"one":1}`, ""},
+		{"image/png", b, nil, "", "", "WriteNegotiated: no acceptable content type"},
+		{"text/*", m, nil, "", "", "WriteNegotiated: cannot marshal value: xml: unsupported type"},
+		{"*/*;q=0.5, application/json;q=0", m, nil, "", "", "WriteNegotiated: cannot marshal value: xml: unsupported type"},
+		{"text/plain", b, nil, "", "", "WriteNegotiated: cannot marshal value: value is not a string"},
+		{"text/*", m, []string{httpx.ContentTypeXML, httpx.ContentTypeJSON}, "", "", "WriteNegotiated: no acceptable content type"},
+		{"", b, []string{"image/png"}, "", "", `WriteNegotiated: cannot marshal value: no codec for "image/png"`},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %q", i, test.accept)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.accept != "" {
+			req.Header.Set(httpx.HeaderAccept, test.accept)
+		}
+		w := httptest.NewRecorder()
+		_, err := httpx.WriteNegotiated(w, req, test.value, test.offers...)
+		if test.err != "" {
+			assert.ErrorContains(err, test.err)
+			assert.Equal(httpx.ErrorStatusCode(err), http.StatusNotAcceptable)
+			assert.Equal(w.Body.Len(), 0)
+			continue
+		}
+		assert.NoError(err)
+		assert.Equal(w.Header().Get(httpx.HeaderContentType), test.contentType)
+		assert.Equal(w.Header().Get(httpx.HeaderVary), httpx.HeaderAccept)
+		assert.Equal(w.Body.String(), test.body)
+	}
+}
+
+// EOF
@@ -12,8 +12,15 @@ package httpx // import "tideland.dev/go/httpx"
 //--------------------
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 //--------------------
@@ -21,43 +28,570 @@ import (
 //--------------------
 
 // NestedMux allows to nest handler following the RESTful API pattern
-// {prefix}/{resource}/{id}/{subresource}/{subresource-id}/...
+// {prefix}/{resource}/{id}/{subresource}/{subresource-id}/... Additionally
+// handlers can be registered for path patterns like /users/{id:int}/avatar.
+// For routes registered with resource paths the resources of the request
+// path are stored in the request context, see ResourcesFromContext().
+// The routes are stored in a radix tree which is replaced when registering
+// or removing a handler. So serving requests needs no locking.
 type NestedMux struct {
-	mu       sync.RWMutex
-	prefix   string
-	handlers map[string]http.Handler
+	mu               sync.Mutex
+	table            atomic.Value
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+	slashes          SlashPolicy
 }
 
 // NewNestedMux creates an empty nested multiplexer.
 func NewNestedMux(prefix string) *NestedMux {
-	return &NestedMux{
-		prefix:   prefix,
-		handlers: make(map[string]http.Handler),
+	return NewNestedMuxWithConfig(prefix, nil)
+}
+
+// NewNestedMuxWithConfig creates an empty nested multiplexer controlled
+// by the given configuration.
+func NewNestedMuxWithConfig(prefix string, config *NestedMuxConfig) *NestedMux {
+	mux := &NestedMux{
+		notFound:         http.NotFoundHandler(),
+		methodNotAllowed: http.HandlerFunc(methodNotAllowed),
 	}
+	if config != nil {
+		if config.NotFound != nil {
+			mux.notFound = config.NotFound
+		}
+		if config.MethodNotAllowed != nil {
+			mux.methodNotAllowed = config.MethodNotAllowed
+		}
+		mux.slashes = config.Slashes
+	}
+	mux.table.Store(newRouteTable(prefix))
+	return mux
 }
 
 // Handle registers the handler for the given resource name. Nested names are separated by a slash.
+// Paths starting with a slash are patterns instead. Their segments are static, parameters like
+// {name}, typed parameters like {id:int}, or a final catch-all parameter like {path...}. Supported
+// types are int, alpha, alnum, hex, and uuid. The matched values can be retrieved with PathParam().
+// Static segments take precedence over typed parameters, those over untyped parameters, and those
+// over resource IDs and catch-all parameters. Handle panics if a pattern is invalid.
 func (mux *NestedMux) Handle(path string, h http.Handler) {
+	mux.handle(path, h, nil)
+}
+
+// Remove unregisters the route for the path like passed to Handle() or
+// Mount(). It returns false if no such route exists.
+func (mux *NestedMux) Remove(path string) bool {
+	removed := false
+	_ = mux.Update(func(u *RouteUpdate) error {
+		removed = u.Remove(path)
+		return nil
+	})
+	return removed
+}
+
+// Update lets the function change a copy of the routing table. It's published
+// atomically when the function returns without error, so requests are served
+// either by the old or the new table and never by a partially changed one. The
+// function must not call other registration methods of the multiplexer.
+func (mux *NestedMux) Update(f func(u *RouteUpdate) error) error {
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
 
-	mux.handlers[path] = h
+	u := &RouteUpdate{
+		table: mux.loadTable(),
+	}
+	if err := f(u); err != nil {
+		return err
+	}
+	mux.table.Store(u.table)
+	return nil
+}
+
+// Mount registers the sub multiplexer for all requests to the path and below.
+// The prefix of the sub multiplexer is replaced by the one of this multiplexer
+// joined with the path. So it doesn't matter how it has been created.
+func (mux *NestedMux) Mount(path string, sub *NestedMux) {
+	mux.mount(path, sub, nil)
+}
+
+// Group returns a route group registering handlers at the multiplexer wrapped
+// by the given wrappers. These are applied in the same order as done by
+// middleware.Wrap(), so e.g. middleware.Wrapper values can be used.
+func (mux *NestedMux) Group(wrappers ...func(h http.Handler) http.Handler) *RouteGroup {
+	return &RouteGroup{
+		mux:      mux,
+		wrappers: wrappers,
+	}
+}
+
+// URL creates the path of the route registered for the name, which is the
+// path passed to Handle(), with the prefix of the multiplexer. For resource
+// paths the resources must match the route. For patterns the parameters are
+// filled with the IDs of the resources with the same names. IDs must not
+// contain slashes, only catch-all parameters may. Routes of mounted
+// multiplexers are found too.
+func (mux *NestedMux) URL(name string, ress Resources) (string, error) {
+	t := mux.loadTable()
+	for _, rt := range t.routes {
+		if rt.mounted != nil || rt.path != name {
+			continue
+		}
+		if rt.resource {
+			if !ress.IsPath(name) {
+				return "", fmt.Errorf("NestedMux: resources %q do not match route %q", ress.Path(), name)
+			}
+			for _, res := range ress {
+				if strings.Contains(res.ID, "/") {
+					// Requests are routed by their unescaped path.
+					return "", fmt.Errorf("NestedMux: invalid ID %q of resource %q", res.ID, res.Name)
+				}
+			}
+			return ResourcesToPath(t.prefix, ress), nil
+		}
+		return rt.pattern.expand(t.prefix, ress)
+	}
+	for _, rt := range t.routes {
+		if rt.mounted == nil {
+			continue
+		}
+		if u, err := rt.mounted.URL(name, ress); err == nil {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("NestedMux: no route %q", name)
+}
+
+// Routes returns information about the registered routes in the order of
+// their registration. Routes of mounted multiplexers are included.
+func (mux *NestedMux) Routes() []RouteInfo {
+	t := mux.loadTable()
+	var infos []RouteInfo
+	for _, rt := range t.routes {
+		middleware := wrapperNames(rt.wrappers)
+		if rt.mounted != nil {
+			for _, info := range rt.mounted.Routes() {
+				info.Middleware = append(append([]string{}, middleware...), info.Middleware...)
+				infos = append(infos, info)
+			}
+			continue
+		}
+		info := RouteInfo{
+			Prefix:     t.prefix,
+			Path:       rt.path,
+			Handler:    handlerName(rt.handler),
+			Middleware: middleware,
+		}
+		if ml, ok := rt.handler.(interface{ Methods() []string }); ok {
+			info.Methods = ml.Methods()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// RoutesHandler returns a read-only handler serving the routes of the
+// multiplexer. They are written as plain text table if accepted by the
+// client, otherwise as JSON.
+func (mux *NestedMux) RoutesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set(HeaderAllow, "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		infos := mux.Routes()
+		if strings.Contains(r.Header.Get(HeaderAccept), ContentTypePlain) {
+			var sb strings.Builder
+			for _, info := range infos {
+				sb.WriteString(info.String())
+				sb.WriteString("\n")
+			}
+			_, _ = WriteBody(w, ContentTypePlain, sb.String())
+			return
+		}
+		if infos == nil {
+			infos = []RouteInfo{}
+		}
+		_, _ = WriteBody(w, ContentTypeJSON, infos)
+	})
 }
 
 // ServeHTTP implements http.Handler.
 func (mux *NestedMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	mux.mu.RLock()
-	defer mux.mu.RUnlock()
+	if mux.slashes == SlashStrip || mux.slashes == SlashRedirect {
+		if path := cleanSlashes(r.URL.Path); path != r.URL.Path {
+			if mux.slashes == SlashRedirect {
+				redirectPath(w, r, path)
+				return
+			}
+			r = withPath(r, path)
+		}
+	}
+	t := mux.loadTable()
+	l, params := t.lookup(r.URL.Path)
+	if l == nil || (mux.slashes == SlashStrict && l.route.resource && hasTrailingSlash(r.URL.Path) && r.URL.Path != t.prefix) {
+		mux.notFound.ServeHTTP(w, r)
+		return
+	}
+	if ma, ok := l.route.handler.(methodAllower); ok && !ma.Allows(r.Method) {
+		w.Header().Set(HeaderAllow, strings.Join(ma.Methods(), ", "))
+		mux.methodNotAllowed.ServeHTTP(w, r)
+		return
+	}
+	l.route.serve.ServeHTTP(w, withRouteValues(r, params, l.route.resource, t.prefix))
+}
+
+// handle adds the route for the handler wrapped by the wrappers to a new
+// routing table and publishes it.
+func (mux *NestedMux) handle(path string, h http.Handler, wrappers []func(h http.Handler) http.Handler) {
+	rt, err := newRoute(path, h, wrappers)
+	if err != nil {
+		panic(fmt.Sprintf("NestedMux: %v", err))
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	mux.table.Store(mux.loadTable().with(rt))
+}
+
+// mount sets the prefix of the sub multiplexer and registers it wrapped
+// by the wrappers for the path.
+func (mux *NestedMux) mount(path string, sub *NestedMux, wrappers []func(h http.Handler) http.Handler) {
+	if sub == mux {
+		panic("NestedMux: cannot mount multiplexer into itself")
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		panic("NestedMux: cannot mount multiplexer without path")
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	sub.setPrefix(joinPrefix(mux.loadTable().prefix, path))
+	mux.table.Store(mux.loadTable().with(newMountRoute(path, sub, wrappers)))
+}
+
+// setPrefix changes the prefix of the multiplexer and of the mounted ones.
+func (mux *NestedMux) setPrefix(prefix string) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	t := mux.loadTable()
+	for _, rt := range t.routes {
+		if rt.mounted != nil {
+			rt.mounted.setPrefix(prefix + strings.TrimPrefix(rt.mounted.loadTable().prefix, t.prefix))
+		}
+	}
+	mux.table.Store(t.withPrefix(prefix))
+}
+
+// loadTable returns the current routing table.
+func (mux *NestedMux) loadTable() *routeTable {
+	t, ok := mux.table.Load().(*routeTable)
+	if !ok {
+		return newRouteTable("")
+	}
+	return t
+}
+
+//--------------------
+// ROUTE UPDATE
+//--------------------
+
+// RouteUpdate collects changes of the routing table of a nested multiplexer
+// inside of NestedMux.Update().
+type RouteUpdate struct {
+	table *routeTable
+}
+
+// Handle registers the handler for the path like NestedMux.Handle(). Invalid
+// patterns are returned as error.
+func (u *RouteUpdate) Handle(path string, h http.Handler) error {
+	rt, err := newRoute(path, h, nil)
+	if err != nil {
+		return fmt.Errorf("NestedMux: %v", err)
+	}
+	u.table = u.table.with(rt)
+	return nil
+}
+
+// Remove unregisters the route for the path like passed to NestedMux.Handle()
+// or NestedMux.Mount(). It returns false if no such route exists.
+func (u *RouteUpdate) Remove(path string) bool {
+	t, ok := u.table.without(path)
+	u.table = t
+	return ok
+}
+
+// Clear removes all routes. So together with Handle() the whole routing
+// table can be replaced.
+func (u *RouteUpdate) Clear() {
+	u.table = newRouteTable(u.table.prefix)
+}
+
+//--------------------
+// CONFIGURATION
+//--------------------
+
+// SlashPolicy defines how the nested multiplexer handles trailing and
+// duplicate slashes in request paths.
+type SlashPolicy int
 
-	ress := PathToResources(r, mux.prefix)
-	path := ress.Path()
-	h, exists := mux.handlers[path]
+const (
+	// SlashLenient lets resource paths match with and without trailing
+	// slash like PathToResources does. It's the default.
+	SlashLenient SlashPolicy = iota
 
-	if !exists {
-		h = http.NotFoundHandler()
+	// SlashStrict lets paths only match as they are. So /users/ doesn't
+	// match the resource path users. Only the prefix itself matches
+	// as configured, e.g. /api/.
+	SlashStrict
+
+	// SlashStrip removes trailing and duplicate slashes before matching.
+	SlashStrip
+
+	// SlashRedirect redirects requests with trailing or duplicate slashes
+	// to the path without them.
+	SlashRedirect
+)
+
+// NestedMuxConfig allows to control how the nested multiplexer works.
+// Default values are:
+//  - NotFound:         http.NotFoundHandler()
+//  - MethodNotAllowed: plain text 405 response
+//  - Slashes:          SlashLenient
+type NestedMuxConfig struct {
+	// NotFound is called if no route matches the request.
+	NotFound http.Handler
+
+	// MethodNotAllowed is called if a route matches but its handler, e.g.
+	// a strict MethodHandler, doesn't allow the request method. The Allow
+	// header is already set.
+	MethodNotAllowed http.Handler
+
+	// Slashes defines the handling of trailing and duplicate slashes.
+	Slashes SlashPolicy
+}
+
+// methodAllower is implemented by handlers telling which methods they allow
+// like the MethodHandler.
+type methodAllower interface {
+	Allows(method string) bool
+	Methods() []string
+}
+
+// methodNotAllowed is the default handler for not allowed methods.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// cleanSlashes removes trailing and duplicate slashes from the path.
+func cleanSlashes(path string) string {
+	if !strings.Contains(path, "//") && !hasTrailingSlash(path) {
+		return path
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && (i+1 == len(path) || path[i+1] == '/') {
+			continue
+		}
+		sb.WriteByte(path[i])
+	}
+	if sb.Len() == 0 {
+		return "/"
+	}
+	return sb.String()
+}
+
+// hasTrailingSlash checks if the path ends with a slash but isn't the root.
+func hasTrailingSlash(path string) bool {
+	return len(path) > 1 && strings.HasSuffix(path, "/")
+}
+
+// redirectPath redirects the request to the path keeping the query. GET and
+// HEAD requests are redirected permanently, all others with a permanent
+// redirect keeping method and body.
+func redirectPath(w http.ResponseWriter, r *http.Request, path string) {
+	u := *r.URL
+	u.Path = path
+	u.RawPath = ""
+	statusCode := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		statusCode = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, u.RequestURI(), statusCode)
+}
+
+// withRouteValues returns a shallow copy of the request with the path
+// parameters and for resource routes the resources stored in its context.
+func withRouteValues(r *http.Request, params PathParams, resource bool, prefix string) *http.Request {
+	if params == nil && !resource {
+		return r
+	}
+	rc := &routeContext{
+		Context:  r.Context(),
+		params:   params,
+		resource: resource,
+	}
+	if resource {
+		rc.request = r
+		rc.prefix = prefix
+	}
+	return r.WithContext(rc)
+}
+
+// routeContext stores the values of a matched route in one context. The
+// resources of a request path are parsed not before they are retrieved
+// the first time.
+type routeContext struct {
+	context.Context
+	params   PathParams
+	resource bool
+	once     sync.Once
+	request  *http.Request
+	prefix   string
+	ress     Resources
+}
+
+// Value implements context.Context.
+func (rc *routeContext) Value(key interface{}) interface{} {
+	switch key {
+	case pathParamsKey:
+		if rc.params != nil {
+			return rc.params
+		}
+	case resourcesKey:
+		if rc.resource {
+			return rc
+		}
+	}
+	return rc.Context.Value(key)
+}
+
+// resources returns the parsed resources.
+func (rc *routeContext) resources() Resources {
+	rc.once.Do(func() {
+		rc.ress = PathToResources(rc.request, rc.prefix)
+		if rc.ress == nil {
+			rc.ress = Resources{}
+		}
+		rc.request = nil
+	})
+	return rc.ress
+}
+
+// withPath returns a shallow copy of the request with a changed path.
+func withPath(r *http.Request, path string) *http.Request {
+	cr := new(http.Request)
+	*cr = *r
+	cr.URL = new(url.URL)
+	*cr.URL = *r.URL
+	cr.URL.Path = path
+	cr.URL.RawPath = ""
+	return cr
+}
+
+//--------------------
+// ROUTE INFO
+//--------------------
+
+// RouteInfo describes a route registered at a nested multiplexer.
+type RouteInfo struct {
+	Prefix     string   `json:"prefix"`
+	Path       string   `json:"path"`
+	Handler    string   `json:"handler"`
+	Middleware []string `json:"middleware,omitempty"`
+	Methods    []string `json:"methods,omitempty"`
+}
+
+// String returns the route info as one line containing the prefix joined
+// with the path, the methods, the handler, and the middleware.
+func (info RouteInfo) String() string {
+	methods := "*"
+	if len(info.Methods) > 0 {
+		methods = strings.Join(info.Methods, ",")
+	}
+	line := fmt.Sprintf("%s %s %s", joinPrefix(info.Prefix, info.Path), methods, info.Handler)
+	if len(info.Middleware) > 0 {
+		line += " [" + strings.Join(info.Middleware, ", ") + "]"
+	}
+	return line
+}
+
+// handlerName returns the type name of a handler. In case of a method
+// handler the type of the wrapped handler is added.
+func handlerName(h http.Handler) string {
+	if mh, ok := h.(*MethodHandler); ok {
+		return fmt.Sprintf("%T(%T)", mh, mh.handler)
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// wrapperNames returns the function names of the wrappers without
+// package path and closure suffixes.
+func wrapperNames(wrappers []func(h http.Handler) http.Handler) []string {
+	var names []string
+	for _, wrapper := range wrappers {
+		name := runtime.FuncForPC(reflect.ValueOf(wrapper).Pointer()).Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+		for {
+			idx := strings.LastIndex(name, ".func")
+			if idx < 0 || strings.Trim(name[idx+5:], "0123456789.") != "" {
+				break
+			}
+			name = name[:idx]
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+//--------------------
+// ROUTE GROUP
+//--------------------
+
+// RouteGroup registers handlers at a nested multiplexer wrapping them
+// with the wrappers of the group.
+type RouteGroup struct {
+	mux      *NestedMux
+	wrappers []func(h http.Handler) http.Handler
+}
+
+// Handle registers the wrapped handler at the multiplexer like NestedMux.Handle().
+func (g *RouteGroup) Handle(path string, h http.Handler) {
+	g.mux.handle(path, h, g.wrappers)
+}
+
+// Mount registers the wrapped sub multiplexer at the multiplexer like NestedMux.Mount().
+func (g *RouteGroup) Mount(path string, sub *NestedMux) {
+	g.mux.mount(path, sub, g.wrappers)
+}
+
+// Group returns a nested route group. Its handlers are wrapped by the given
+// wrappers first and then by the ones of this group.
+func (g *RouteGroup) Group(wrappers ...func(h http.Handler) http.Handler) *RouteGroup {
+	return &RouteGroup{
+		mux:      g.mux,
+		wrappers: append(append([]func(h http.Handler) http.Handler{}, wrappers...), g.wrappers...),
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// wrap wraps the handler with all wrappers.
+func wrap(h http.Handler, wrappers []func(h http.Handler) http.Handler) http.Handler {
+	for _, wrapper := range wrappers {
+		h = wrapper(h)
 	}
+	return h
+}
 
-	h.ServeHTTP(w, r)
+// joinPrefix joins a prefix and a path with exactly one slash.
+func joinPrefix(prefix, path string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.Trim(path, "/")
 }
 
 // EOF
@@ -12,13 +12,18 @@ package httpx_test // import "tideland.dev/go/httpx"
 //--------------------
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"tideland.dev/go/audit/asserts"
 	"tideland.dev/go/audit/web"
 
 	"tideland.dev/go/httpx"
+	"tideland.dev/go/httpx/middleware"
 )
 
 //--------------------
@@ -102,4 +107,711 @@ func TestNestedMux(t *testing.T) {
 	}
 }
 
+// TestNestedMuxPatterns tests the mapping of requests to handlers
+// registered for path patterns.
+func TestNestedMuxPatterns(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api/")
+
+	nmux.Handle("users", makeEchoHandler(assert, "users"))
+	nmux.Handle("/users/{id:int}/avatar", makeParamsHandler(assert, "avatar", "id"))
+	nmux.Handle("/users/{name}/avatar", makeParamsHandler(assert, "named-avatar", "name"))
+	nmux.Handle("/users/me/avatar", makeParamsHandler(assert, "my-avatar"))
+	nmux.Handle("/files/{path...}", makeParamsHandler(assert, "files", "path"))
+	nmux.Handle("/status", makeParamsHandler(assert, "status"))
+	nmux.Handle("/items/{id:uuid}", makeParamsHandler(assert, "item", "id"))
+
+	s := web.NewSimulator(nmux)
+
+	tests := []struct {
+		path       string
+		statusCode int
+		body       string
+	}{
+		{
+			path:       "/api/users/4711",
+			statusCode: http.StatusOK,
+			body:       "users: GET /api/users/4711",
+		}, {
+			path:       "/api/users/4711/avatar",
+			statusCode: http.StatusOK,
+			body:       "avatar: id=4711",
+		}, {
+			path:       "/api/users/foo/avatar",
+			statusCode: http.StatusOK,
+			body:       "named-avatar: name=foo",
+		}, {
+			path:       "/api/users/me/avatar",
+			statusCode: http.StatusOK,
+			body:       "my-avatar:",
+		}, {
+			path:       "/api/users//avatar",
+			statusCode: http.StatusNotFound,
+			body:       "404 page not found\n",
+		}, {
+			path:       "/api/files/docs/2022/report.pdf",
+			statusCode: http.StatusOK,
+			body:       "files: path=docs/2022/report.pdf",
+		}, {
+			path:       "/api/status",
+			statusCode: http.StatusOK,
+			body:       "status:",
+		}, {
+			path:       "/api/status/more",
+			statusCode: http.StatusNotFound,
+			body:       "404 page not found\n",
+		}, {
+			path:       "/api/items/0b4c1a6e-8d5a-4c8e-9a43-6f1e2d3c4b5a",
+			statusCode: http.StatusOK,
+			body:       "item: id=0b4c1a6e-8d5a-4c8e-9a43-6f1e2d3c4b5a",
+		}, {
+			path:       "/api/items/4711",
+			statusCode: http.StatusNotFound,
+			body:       "404 page not found\n",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.path)
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+}
+
+// TestNestedMuxInvalidPatterns tests the rejection of invalid patterns.
+func TestNestedMuxInvalidPatterns(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api/")
+	h := makeEchoHandler(assert, "invalid")
+
+	for _, path := range []string{
+		"/users/{id:float}",
+		"/users/{}",
+		"/users/{id}/{id}",
+		"/files/{path...}/more",
+		"/users/{id",
+		"/users/x{id}",
+	} {
+		assert.Logf("pattern %q", path)
+		assert.Panics(func() {
+			nmux.Handle(path, h)
+		})
+	}
+}
+
+// TestNestedMuxPrecedence tests the precedence of static segments, typed
+// and untyped parameters, resource IDs, and catch-all parameters.
+func TestNestedMuxPrecedence(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/")
+
+	nmux.Handle("docs", makeParamsHandler(assert, "docs"))
+	nmux.Handle("docs/pages", makeParamsHandler(assert, "pages"))
+	nmux.Handle("/docs/index", makeParamsHandler(assert, "index"))
+	nmux.Handle("/docs/{no:int}/raw", makeParamsHandler(assert, "raw", "no"))
+	nmux.Handle("/docs/{name}/raw", makeParamsHandler(assert, "named-raw", "name"))
+	nmux.Handle("/docs/{name}/history", makeParamsHandler(assert, "history", "name"))
+	nmux.Handle("/docsearch/{query...}", makeParamsHandler(assert, "search", "query"))
+	nmux.Handle("/do", makeParamsHandler(assert, "do"))
+
+	s := web.NewSimulator(nmux)
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/docs", "docs:"},
+		{"/docs/", "docs:"},
+		{"/docs/42", "docs:"},
+		{"/docs/42/", "docs:"},
+		{"/docs/index", "index:"},
+		{"/docs/42/raw", "raw: no=42"},
+		{"/docs/readme/raw", "named-raw: name=readme"},
+		{"/docs/42/history", "history: name=42"},
+		{"/docs/42/pages", "pages:"},
+		{"/docs/42/pages/1", "pages:"},
+		{"/docsearch/a/b/c", "search: query=a/b/c"},
+		{"/docsearch/", "search: query="},
+		{"/docsearch", "search: query="},
+		{"/do", "do:"},
+		{"/d", "404 page not found\n"},
+		{"/docs/42/raw/more", "404 page not found\n"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.path)
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+}
+
+// TestNestedMuxMount tests the mounting of multiplexers into others.
+func TestNestedMuxMount(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	api := httpx.NewNestedMux("/api")
+	v1 := httpx.NewNestedMux("")
+	v2 := httpx.NewNestedMux("/anything")
+	admin := httpx.NewNestedMux("")
+
+	v1.Handle("users", makeEchoHandler(assert, "v1-users"))
+	v2.Handle("users", makeEchoHandler(assert, "v2-users"))
+	v2.Handle("/status", makeParamsHandler(assert, "v2-status"))
+	admin.Handle("/stats/{name}", makeParamsHandler(assert, "admin-stats", "name"))
+	v2.Mount("admin", admin)
+	api.Mount("v1", v1)
+	api.Mount("/v2/", v2)
+
+	s := web.NewSimulator(api)
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/api/v1/users/1", "v1-users: GET /api/v1/users/1"},
+		{"/api/v2/users/2", "v2-users: GET /api/v2/users/2"},
+		{"/api/v2/status", "v2-status:"},
+		{"/api/v2/admin/stats/requests", "admin-stats: name=requests"},
+		{"/api/v1/status", "404 page not found\n"},
+		{"/api/v3/users/1", "404 page not found\n"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.path)
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+
+	assert.Panics(func() {
+		api.Mount("self", api)
+	})
+}
+
+// TestNestedMuxGroup tests the wrapping of handlers registered by
+// route groups.
+func TestNestedMuxGroup(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api")
+	sub := httpx.NewNestedMux("")
+
+	sub.Handle("items", makeEchoHandler(assert, "items"))
+	nmux.Handle("public", makeEchoHandler(assert, "public"))
+	group := nmux.Group(makeTagWrapper("outer"), middleware.WrapETag("v1"))
+	group.Handle("users", makeEchoHandler(assert, "users"))
+	group.Mount("sub", sub)
+	inner := group.Group(makeTagWrapper("inner"))
+	inner.Handle("orders", makeEchoHandler(assert, "orders"))
+
+	s := web.NewSimulator(nmux)
+
+	tests := []struct {
+		path string
+		tags []string
+		etag string
+	}{
+		{"/api/public", nil, ""},
+		{"/api/users/1", []string{"outer"}, "v1"},
+		{"/api/sub/items/1", []string{"outer"}, "v1"},
+		{"/api/orders/1", []string{"outer", "inner"}, "v1"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.path)
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, http.StatusOK)
+		assert.Equal(resp.Header.Values("X-Tag"), test.tags)
+		assert.Equal(resp.Header.Get(middleware.HeaderETag), test.etag)
+	}
+}
+
+// TestNestedMuxRoutes tests the listing of the registered routes.
+func TestNestedMuxRoutes(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api")
+	sub := httpx.NewNestedMux("")
+
+	nmux.Handle("users", httpx.NewMethodHandler(getHandler{}))
+	sub.Handle("/items/{id:int}", makeEchoHandler(assert, "items"))
+	group := nmux.Group(makeTagWrapper("group"), middleware.WrapETag("v1"))
+	group.Handle("/status", makeEchoHandler(assert, "status"))
+	group.Mount("sub", sub)
+
+	routes := nmux.Routes()
+	assert.Length(routes, 3)
+	assert.Equal(routes[0], httpx.RouteInfo{
+		Prefix:  "/api",
+		Path:    "users",
+		Handler: "*httpx.MethodHandler(httpx_test.getHandler)",
+		Methods: []string{http.MethodGet, http.MethodHead, http.MethodOptions},
+	})
+	assert.Equal(routes[1], httpx.RouteInfo{
+		Prefix:     "/api",
+		Path:       "/status",
+		Handler:    "http.HandlerFunc",
+		Middleware: []string{"httpx_test.makeTagWrapper", "middleware.WrapETag"},
+	})
+	assert.Equal(routes[2], httpx.RouteInfo{
+		Prefix:     "/api/sub",
+		Path:       "/items/{id:int}",
+		Handler:    "http.HandlerFunc",
+		Middleware: []string{"httpx_test.makeTagWrapper", "middleware.WrapETag"},
+	})
+
+	// Serve routes as JSON and as plain text.
+	s := web.NewSimulator(nmux.RoutesHandler())
+	resp, err := s.Get("/")
+	assert.NoError(err)
+	assert.Equal(resp.StatusCode, http.StatusOK)
+	var infos []httpx.RouteInfo
+	assert.NoError(web.BodyToJSON(resp, &infos))
+	assert.Equal(infos, routes)
+
+	req := s.CreateRequest(http.MethodGet, "/", nil)
+	req.Header.Set(httpx.HeaderAccept, httpx.ContentTypePlain)
+	resp, err = s.Do(req)
+	assert.NoError(err)
+	body, err := web.BodyToString(resp)
+	assert.NoError(err)
+	assert.Equal(body, "/api/users GET,HEAD,OPTIONS *httpx.MethodHandler(httpx_test.getHandler)\n"+
+		"/api/status * http.HandlerFunc [httpx_test.makeTagWrapper, middleware.WrapETag]\n"+
+		"/api/sub/items/{id:int} * http.HandlerFunc [httpx_test.makeTagWrapper, middleware.WrapETag]\n")
+
+	resp, err = s.Post("/", httpx.ContentTypeJSON, nil)
+	assert.NoError(err)
+	assert.Equal(resp.StatusCode, http.StatusMethodNotAllowed)
+}
+
+// TestNestedMuxURL tests the creation of URLs for registered routes.
+func TestNestedMuxURL(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api")
+	sub := httpx.NewNestedMux("")
+	h := makeEchoHandler(assert, "url")
+
+	nmux.Handle("users/orders", h)
+	nmux.Handle("/users/{user:int}/avatar", h)
+	nmux.Handle("/users/{name}/avatar", h)
+	nmux.Handle("/files/{path...}", h)
+	sub.Handle("items", h)
+	nmux.Mount("v1", sub)
+
+	tests := []struct {
+		name string
+		ress httpx.Resources
+		url  string
+		err  string
+	}{
+		{
+			name: "users/orders",
+			ress: httpx.Resources{{"users", "42"}, {"orders", "7"}},
+			url:  "/api/users/42/orders/7",
+		}, {
+			name: "users/orders",
+			ress: httpx.Resources{{"users", "42"}, {"orders", ""}},
+			url:  "/api/users/42/orders",
+		}, {
+			name: "/users/{user:int}/avatar",
+			ress: httpx.Resources{{"user", "42"}},
+			url:  "/api/users/42/avatar",
+		}, {
+			name: "/files/{path...}",
+			ress: httpx.Resources{{"path", "docs/my report.pdf"}},
+			url:  "/api/files/docs/my%20report.pdf",
+		}, {
+			name: "items",
+			ress: httpx.Resources{{"items", "1"}},
+			url:  "/api/v1/items/1",
+		}, {
+			name: "users/orders",
+			ress: httpx.Resources{{"users", "42"}},
+			err:  `resources "users" do not match route "users/orders"`,
+		}, {
+			name: "/users/{user:int}/avatar",
+			ress: httpx.Resources{{"user", "foo"}},
+			err:  `invalid value "foo" for parameter "user"`,
+		}, {
+			name: "/users/{user:int}/avatar",
+			err:  `no value for parameter "user"`,
+		}, {
+			name: "users/orders",
+			ress: httpx.Resources{{"users", "a/b"}, {"orders", "7"}},
+			err:  `invalid ID "a/b" of resource "users"`,
+		}, {
+			name: "/users/{name}/avatar",
+			ress: httpx.Resources{{"name", "a/b"}},
+			err:  `invalid value "a/b" for parameter "name"`,
+		}, {
+			name: "users/orders",
+			ress: httpx.Resources{{"users", "John Doe?"}, {"orders", "#7"}},
+			url:  "/api/users/John%20Doe%3F/orders/%237",
+		}, {
+			name: "unknown",
+			err:  `no route "unknown"`,
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		url, err := nmux.URL(test.name, test.ress)
+		if test.err != "" {
+			assert.ErrorContains(err, test.err)
+			continue
+		}
+		assert.NoError(err)
+		assert.Equal(url, test.url)
+	}
+}
+
+// TestNestedMuxFallbacks tests the configured not found and method
+// not allowed handlers.
+func TestNestedMuxFallbacks(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMuxWithConfig("/api", &httpx.NestedMuxConfig{
+		NotFound:         httpx.ProblemHandler(http.StatusNotFound),
+		MethodNotAllowed: httpx.ProblemHandler(http.StatusMethodNotAllowed),
+	})
+	nmux.Handle("strict", httpx.NewMethodHandlerWithConfig(getHandler{}, &httpx.MethodHandlerConfig{
+		Strict: true,
+	}))
+	nmux.Handle("lax", httpx.NewMethodHandler(getHandler{}))
+
+	s := web.NewSimulator(nmux)
+
+	tests := []struct {
+		method      string
+		path        string
+		statusCode  int
+		contentType string
+		allow       string
+		body        string
+	}{
+		{
+			method:      http.MethodGet,
+			path:        "/api/unknown",
+			statusCode:  http.StatusNotFound,
+			contentType: httpx.ContentTypeProblemJSON,
+			body:        `{"title":"Not Found","status":404,"instance":"/api/unknown"}`,
+		}, {
+			method:      http.MethodGet,
+			path:        "/api/strict/1",
+			statusCode:  http.StatusAccepted,
+			contentType: httpx.ContentTypePlain,
+			body:        "METHOD: GET!",
+		}, {
+			method:      http.MethodPost,
+			path:        "/api/strict/1",
+			statusCode:  http.StatusMethodNotAllowed,
+			contentType: httpx.ContentTypeProblemJSON,
+			allow:       "GET, HEAD, OPTIONS",
+			body:        `{"title":"Method Not Allowed","status":405,"instance":"/api/strict/1"}`,
+		}, {
+			method:      http.MethodPost,
+			path:        "/api/lax/1",
+			statusCode:  http.StatusBadRequest,
+			contentType: httpx.ContentTypePlain,
+			body:        "bad request\n",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s %s", i, test.method, test.path)
+		req := s.CreateRequest(test.method, test.path, nil)
+		resp, err := s.Do(req)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		assert.Contains(test.contentType, resp.Header.Get(httpx.HeaderContentType))
+		assert.Equal(resp.Header.Get(httpx.HeaderAllow), test.allow)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+}
+
+// TestNestedMuxSlashes tests the different slash policies.
+func TestNestedMuxSlashes(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		policy     httpx.SlashPolicy
+		path       string
+		statusCode int
+		location   string
+		body       string
+	}{
+		{httpx.SlashLenient, "/api/users", http.StatusOK, "", "users: GET /api/users"},
+		{httpx.SlashLenient, "/api/users/", http.StatusOK, "", "users: GET /api/users/"},
+		{httpx.SlashLenient, "/api/users/1/", http.StatusOK, "", "users: GET /api/users/1/"},
+		{httpx.SlashLenient, "/api/status/", http.StatusNotFound, "", "404 page not found\n"},
+		{httpx.SlashStrict, "/api/users", http.StatusOK, "", "users: GET /api/users"},
+		{httpx.SlashStrict, "/api/users/1", http.StatusOK, "", "users: GET /api/users/1"},
+		{httpx.SlashStrict, "/api/users/", http.StatusNotFound, "", "404 page not found\n"},
+		{httpx.SlashStrict, "/api/users/1/", http.StatusNotFound, "", "404 page not found\n"},
+		{httpx.SlashStrip, "/api/users/", http.StatusOK, "", "users: GET /api/users"},
+		{httpx.SlashStrip, "/api//users//1/", http.StatusOK, "", "users: GET /api/users/1"},
+		{httpx.SlashStrip, "/api/status/", http.StatusOK, "", "status: GET /api/status"},
+		{httpx.SlashRedirect, "/api/users", http.StatusOK, "", "users: GET /api/users"},
+		{httpx.SlashRedirect, "/api/users/1/?x=y", http.StatusMovedPermanently, "/api/users/1?x=y", ""},
+		{httpx.SlashRedirect, "/api//status", http.StatusMovedPermanently, "/api/status", ""},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: policy %d %s", i, test.policy, test.path)
+		nmux := httpx.NewNestedMuxWithConfig("/api", &httpx.NestedMuxConfig{
+			Slashes: test.policy,
+		})
+		nmux.Handle("users", makeEchoHandler(assert, "users"))
+		nmux.Handle("/status", makeEchoHandler(assert, "status"))
+		s := web.NewSimulator(nmux)
+
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		assert.Equal(resp.Header.Get("Location"), test.location)
+		if test.body != "" {
+			body, err := web.BodyToString(resp)
+			assert.NoError(err)
+			assert.Equal(body, test.body)
+		}
+	}
+}
+
+// TestNestedMuxSlashesRoot tests the root resource route matching the
+// prefix under the different slash policies.
+func TestNestedMuxSlashesRoot(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		policy     httpx.SlashPolicy
+		prefix     string
+		path       string
+		statusCode int
+		location   string
+		body       string
+	}{
+		{httpx.SlashLenient, "/api/", "/api/", http.StatusOK, "", "root: GET /api/"},
+		{httpx.SlashLenient, "/api/", "/api", http.StatusOK, "", "root: GET /api"},
+		{httpx.SlashLenient, "/api", "/api/", http.StatusOK, "", "root: GET /api/"},
+		{httpx.SlashStrict, "/api/", "/api/", http.StatusOK, "", "root: GET /api/"},
+		{httpx.SlashStrict, "/api/", "/api", http.StatusOK, "", "root: GET /api"},
+		{httpx.SlashStrict, "/api", "/api", http.StatusOK, "", "root: GET /api"},
+		{httpx.SlashStrict, "/api", "/api/", http.StatusNotFound, "", "404 page not found\n"},
+		{httpx.SlashStrip, "/api/", "/api/", http.StatusOK, "", "root: GET /api"},
+		{httpx.SlashStrip, "/api", "/api//", http.StatusOK, "", "root: GET /api"},
+		{httpx.SlashRedirect, "/api/", "/api", http.StatusOK, "", "root: GET /api"},
+		{httpx.SlashRedirect, "/api/", "/api/", http.StatusMovedPermanently, "/api", ""},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: policy %d prefix %s path %s", i, test.policy, test.prefix, test.path)
+		nmux := httpx.NewNestedMuxWithConfig(test.prefix, &httpx.NestedMuxConfig{
+			Slashes: test.policy,
+		})
+		nmux.Handle("", makeEchoHandler(assert, "root"))
+		nmux.Handle("users", makeEchoHandler(assert, "users"))
+		s := web.NewSimulator(nmux)
+
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		assert.Equal(resp.Header.Get("Location"), test.location)
+		if test.body != "" {
+			body, err := web.BodyToString(resp)
+			assert.NoError(err)
+			assert.Equal(body, test.body)
+		}
+	}
+}
+
+// TestNestedMuxUpdate tests the removing and replacing of routes.
+func TestNestedMuxUpdate(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api")
+	sub := httpx.NewNestedMux("")
+	sub.Handle("items", makeEchoHandler(assert, "items"))
+
+	nmux.Handle("users", makeEchoHandler(assert, "users"))
+	nmux.Handle("users/orders", makeEchoHandler(assert, "orders"))
+	nmux.Handle("/status", makeEchoHandler(assert, "status"))
+	nmux.Mount("sub", sub)
+
+	s := web.NewSimulator(nmux)
+	check := func(path string, statusCode int) {
+		resp, err := s.Get(path)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, statusCode, path)
+	}
+
+	check("/api/users/1/orders/2", http.StatusOK)
+	assert.True(nmux.Remove("users/orders"))
+	assert.False(nmux.Remove("users/orders"))
+	check("/api/users/1/orders/2", http.StatusNotFound)
+	check("/api/users/1", http.StatusOK)
+	check("/api/sub/items/1", http.StatusOK)
+	assert.True(nmux.Remove("sub"))
+	check("/api/sub/items/1", http.StatusNotFound)
+	assert.Length(nmux.Routes(), 2)
+
+	// Failing update keeps the table.
+	err := nmux.Update(func(u *httpx.RouteUpdate) error {
+		u.Clear()
+		return u.Handle("/invalid/{id:float}", makeEchoHandler(assert, "invalid"))
+	})
+	assert.ErrorContains(err, `invalid type "float"`)
+	check("/api/users/1", http.StatusOK)
+	check("/api/status", http.StatusOK)
+
+	// Replace the whole table.
+	err = nmux.Update(func(u *httpx.RouteUpdate) error {
+		u.Clear()
+		if err := u.Handle("customers", makeEchoHandler(assert, "customers")); err != nil {
+			return err
+		}
+		return u.Handle("/status", makeEchoHandler(assert, "new-status"))
+	})
+	assert.NoError(err)
+	check("/api/users/1", http.StatusNotFound)
+	check("/api/customers/1", http.StatusOK)
+	resp, err := s.Get("/api/status")
+	assert.NoError(err)
+	body, err := web.BodyToString(resp)
+	assert.NoError(err)
+	assert.Equal(body, "new-status: GET /api/status")
+}
+
+// TestNestedMuxConcurrentUpdate tests the serving of requests while
+// the routes are changed.
+func TestNestedMuxConcurrentUpdate(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api")
+	nmux.Handle("stable", makeEchoHandler(assert, "stable"))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := httptest.NewRecorder()
+				nmux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/stable/1", nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("unexpected status code %d", w.Code)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("feature%d", i)
+		nmux.Handle(path, makeEchoHandler(assert, path))
+		if i%2 == 0 {
+			assert.True(nmux.Remove(path))
+		}
+	}
+	close(done)
+	wg.Wait()
+	assert.Length(nmux.Routes(), 51)
+}
+
+// TestNestedMuxResources tests the storing of the resources in the
+// request context.
+func TestNestedMuxResources(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nmux := httpx.NewNestedMux("/api")
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		ress, ok := httpx.ResourcesFromContext(r.Context())
+		if !ok {
+			return httpx.StatusErrorf(http.StatusNotFound, "no resources")
+		}
+		userID, err := ress.IDInt("users")
+		if err != nil {
+			return err
+		}
+		_, err = httpx.WriteBody(w, httpx.ContentTypePlain, fmt.Sprintf("user %d of %s", userID, ress.Path()))
+		return err
+	}
+	nmux.Handle("users/orders", makeErrorHandler(h))
+	nmux.Handle("/users/{id}/avatar", makeErrorHandler(h))
+
+	s := web.NewSimulator(nmux)
+
+	tests := []struct {
+		path       string
+		statusCode int
+		body       string
+	}{
+		{"/api/users/42/orders/1", http.StatusOK, "user 42 of users/orders"},
+		{"/api/users/42/orders/", http.StatusOK, "user 42 of users/orders"},
+		{"/api/users/foo/orders", http.StatusBadRequest, `ID "foo" of resource "users" is no integer` + "\n"},
+		{"/api/users//orders", http.StatusBadRequest, `resource "users" has no ID` + "\n"},
+		{"/api/users/42/avatar", http.StatusNotFound, "no resources\n"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.path)
+		resp, err := s.Get(test.path)
+		assert.NoError(err)
+		assert.Equal(resp.StatusCode, test.statusCode)
+		body, err := web.BodyToString(resp)
+		assert.NoError(err)
+		assert.Equal(body, test.body)
+	}
+}
+
+//--------------------
+// BENCHMARKS
+//--------------------
+
+// BenchmarkNestedMux measures the routing of the nested multiplexer
+// with a larger number of routes.
+func BenchmarkNestedMux(b *testing.B) {
+	nmux := httpx.NewNestedMux("/api/")
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i < 1000; i++ {
+		nmux.Handle(fmt.Sprintf("resource%d/sub", i), h)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/resource500/4711/sub/1", nil)
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nmux.ServeHTTP(w, req)
+	}
+}
+
+// BenchmarkMapLookup measures the former routing of the nested multiplexer
+// by a map of resource paths as comparison. Like the nested multiplexer it
+// passes the resources to the handler in the request context.
+func BenchmarkMapLookup(b *testing.B) {
+	type resourcesKey struct{}
+	var mu sync.RWMutex
+	handlers := make(map[string]http.Handler)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i < 1000; i++ {
+		handlers[fmt.Sprintf("resource%d/sub", i)] = h
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/resource500/4711/sub/1", nil)
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mu.RLock()
+		ress := httpx.PathToResources(req, "/api/")
+		h, ok := handlers[ress.Path()]
+		mu.RUnlock()
+		if !ok {
+			b.Fatal("handler not found")
+		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), resourcesKey{}, ress)))
+	}
+}
+
 // EOF
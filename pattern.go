@@ -0,0 +1,248 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//--------------------
+// PATH PARAMETERS
+//--------------------
+
+// PathParams contains the values of the parameters of a matched path pattern
+// by their names.
+type PathParams map[string]string
+
+// PathParamsFromContext returns the path parameters stored in the context by
+// the nested multiplexer.
+func PathParamsFromContext(ctx context.Context) PathParams {
+	params, _ := ctx.Value(pathParamsKey).(PathParams)
+	return params
+}
+
+// PathParam returns the value of the named path parameter of the request or
+// an empty string if it doesn't exist.
+func PathParam(r *http.Request, name string) string {
+	return PathParamsFromContext(r.Context())[name]
+}
+
+//--------------------
+// PARAMETER TYPES
+//--------------------
+
+// paramTypes contains the checks of the typed path parameters.
+var paramTypes = map[string]func(value string) bool{
+	"int": func(value string) bool {
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	},
+	"alpha": func(value string) bool {
+		return allRunes(value, func(r rune) bool {
+			return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		})
+	},
+	"alnum": func(value string) bool {
+		return allRunes(value, func(r rune) bool {
+			return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+		})
+	},
+	"hex": func(value string) bool {
+		return allRunes(value, isHex)
+	},
+	"uuid": isUUID,
+}
+
+// allRunes checks if the value is not empty and all runes pass the check.
+func allRunes(value string, check func(r rune) bool) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if !check(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isHex checks if the rune is a hexadecimal digit.
+func isHex(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
+// isUUID checks if the value is a UUID in its canonical textual form.
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i, r := range value {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !isHex(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//--------------------
+// PATH PATTERN
+//--------------------
+
+// segmentKind describes how a pattern segment matches a path segment.
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	catchAllSegment
+)
+
+// segment is one part of a path pattern between two slashes. Parameters
+// for resource IDs have no name and may be empty.
+type segment struct {
+	kind  segmentKind
+	value string
+	typ   string
+	check func(value string) bool
+	empty bool
+}
+
+// pattern is a parsed path pattern like /users/{id:int}/avatar
+// or /files/{path...}.
+type pattern struct {
+	raw      string
+	segments []segment
+}
+
+// isPattern checks if the path registered at the nested multiplexer is a
+// pattern. Those start with a slash while resource paths don't.
+func isPattern(path string) bool {
+	return strings.HasPrefix(path, "/")
+}
+
+// resourcePattern converts a resource path like users/orders into a pattern
+// with an ID parameter after each name. Those match users/{id}/orders/{id}.
+func resourcePattern(path string) *pattern {
+	p := &pattern{
+		raw: path,
+	}
+	if path == "" {
+		p.segments = []segment{{kind: staticSegment}}
+		return p
+	}
+	for i, name := range strings.Split(path, "/") {
+		if i > 0 {
+			p.segments = append(p.segments, segment{kind: paramSegment, empty: true})
+		}
+		p.segments = append(p.segments, segment{kind: staticSegment, value: name})
+	}
+	return p
+}
+
+// parsePattern parses a path pattern. Segments are static, parameters in
+// braces optionally with a type like {id:int}, or a final catch-all
+// parameter like {path...}.
+func parsePattern(raw string) (*pattern, error) {
+	p := &pattern{
+		raw: raw,
+	}
+	parts := strings.Split(strings.TrimPrefix(raw, "/"), "/")
+	names := make(map[string]bool)
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("invalid segment %q in pattern %q", part, raw)
+			}
+			p.segments = append(p.segments, segment{kind: staticSegment, value: part})
+			continue
+		}
+		seg := segment{kind: paramSegment}
+		name := part[1 : len(part)-1]
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("catch-all parameter %q in pattern %q is not last", part, raw)
+			}
+			seg.kind = catchAllSegment
+			name = strings.TrimSuffix(name, "...")
+		}
+		if idx := strings.Index(name, ":"); idx >= 0 {
+			typ := name[idx+1:]
+			check, ok := paramTypes[typ]
+			if !ok || seg.kind == catchAllSegment {
+				return nil, fmt.Errorf("invalid type %q of parameter in pattern %q", typ, raw)
+			}
+			seg.typ = typ
+			seg.check = check
+			name = name[:idx]
+		}
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("invalid parameter %q in pattern %q", part, raw)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate parameter %q in pattern %q", name, raw)
+		}
+		names[name] = true
+		seg.value = name
+		p.segments = append(p.segments, seg)
+	}
+	return p, nil
+}
+
+// expand creates a path out of the prefix and the pattern. The parameters
+// are filled with the IDs of the resources with the same names.
+func (p *pattern) expand(prefix string, ress Resources) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSuffix(prefix, "/"))
+	for _, seg := range p.segments {
+		sb.WriteString("/")
+		if seg.kind == staticSegment {
+			sb.WriteString(seg.value)
+			continue
+		}
+		value, ok := "", false
+		for _, res := range ress {
+			if res.Name == seg.value {
+				value, ok = res.ID, true
+				break
+			}
+		}
+		switch {
+		case !ok:
+			return "", fmt.Errorf("NestedMux: no value for parameter %q of route %q", seg.value, p.raw)
+		case seg.kind == catchAllSegment:
+			parts := strings.Split(value, "/")
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+			sb.WriteString(strings.Join(parts, "/"))
+		case value == "" || strings.Contains(value, "/") || (seg.check != nil && !seg.check(value)):
+			return "", fmt.Errorf("NestedMux: invalid value %q for parameter %q of route %q", value, seg.value, p.raw)
+		default:
+			sb.WriteString(url.PathEscape(value))
+		}
+	}
+	return sb.String(), nil
+}
+
+// EOF
@@ -0,0 +1,340 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------
+// FIELD ERRORS
+//--------------------
+
+// FieldError describes the failure of binding a value to a struct field.
+type FieldError struct {
+	Field string
+	Key   string
+	Value string
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid value %q of %q for field %s: %v", e.Value, e.Key, e.Field, e.Err)
+}
+
+// Unwrap returns the wrapped error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors collects the errors of all fields failed during binding.
+type FieldErrors []*FieldError
+
+// Error implements the error interface.
+func (es FieldErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return "cannot bind fields: " + strings.Join(msgs, "; ")
+}
+
+// StatusCode implements the StatusCoder interface. Invalid values
+// are always a bad request.
+func (es FieldErrors) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+//--------------------
+// QUERY BINDING
+//--------------------
+
+// ReadQuery decodes the query of the request into the struct the given value
+// points to. Fields are bound by their tag like `query:"limit"` or by their
+// name. A tag "-" skips the field. Supported are strings, ints, uints, floats,
+// bools, time.Time as RFC 3339 or date, time.Duration, encoding.TextUnmarshaler,
+// and pointers to them. Slices are filled with repeated keys, embedded structs
+// are bound as if their fields were part of the outer struct. A present key
+// without value sets a bool to true. All invalid fields are returned as
+// FieldErrors answering with a 400 Bad Request.
+func ReadQuery(r *http.Request, value interface{}) error {
+	if err := bindValues(r.URL.Query(), "query", value); err != nil {
+		return fmt.Errorf("ReadQuery: %w", err)
+	}
+	return nil
+}
+
+//--------------------
+// BINDER
+//--------------------
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	textUnmarshalType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	formFileType      = reflect.TypeOf((*FormFile)(nil))
+	formFilesType     = reflect.TypeOf([]*FormFile{})
+)
+
+// binder binds string values and form files to the fields of a struct.
+type binder struct {
+	values map[string][]string
+	files  map[string][]*FormFile
+	bound  map[*FormFile]bool
+	tag    string
+	errs   FieldErrors
+}
+
+// bindValues binds the values to the fields of the struct the value points
+// to. The fields are identified by the given tag.
+func bindValues(values map[string][]string, tag string, value interface{}) error {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("value is not a struct pointer")
+	}
+	b := &binder{
+		values: values,
+		tag:    tag,
+	}
+	return b.bind(rv)
+}
+
+// bind binds to the struct the value points to.
+func (b *binder) bind(rv reflect.Value) error {
+	b.bindStruct(rv.Elem(), "")
+	if len(b.errs) > 0 {
+		return b.errs
+	}
+	return nil
+}
+
+// bindStruct binds the values to the fields of the struct.
+func (b *binder) bindStruct(rv reflect.Value, path string) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get(b.tag)
+		if tag == "-" {
+			continue
+		}
+		fv := rv.Field(i)
+		if sf.Anonymous && tag == "" && isEmbeddedStruct(sf.Type) {
+			if sf.Type.Kind() == reflect.Ptr {
+				if !fv.CanSet() {
+					continue
+				}
+				if fv.IsNil() {
+					if !b.hasAny(sf.Type.Elem()) {
+						continue
+					}
+					fv.Set(reflect.New(sf.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			b.bindStruct(fv, path+sf.Name+".")
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(tag, ",")[0]
+		if key == "" {
+			key = sf.Name
+		}
+		if b.bindFiles(fv, key) {
+			continue
+		}
+		vs := b.values[key]
+		if len(vs) == 0 {
+			continue
+		}
+		if err := bindField(fv, vs); err != nil {
+			b.errs = append(b.errs, &FieldError{
+				Field: path + sf.Name,
+				Key:   key,
+				Value: strings.Join(vs, ","),
+				Err:   err,
+			})
+		}
+	}
+}
+
+// hasAny checks if any key for the fields of the struct type exists. It
+// avoids allocating embedded struct pointers for nothing.
+func (b *binder) hasAny(rt reflect.Type) bool {
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get(b.tag)
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" && isEmbeddedStruct(sf.Type) {
+			et := sf.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if b.hasAny(et) {
+				return true
+			}
+			continue
+		}
+		key := strings.Split(tag, ",")[0]
+		if key == "" {
+			key = sf.Name
+		}
+		if len(b.values[key]) > 0 || len(b.files[key]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bindFiles sets fields of the types *FormFile and []*FormFile to the
+// files of the key. It returns false for fields of other types.
+func (b *binder) bindFiles(fv reflect.Value, key string) bool {
+	files := b.files[key]
+	switch fv.Type() {
+	case formFileType:
+		if len(files) > 0 {
+			fv.Set(reflect.ValueOf(files[0]))
+			b.bound[files[0]] = true
+		}
+	case formFilesType:
+		if len(files) > 0 {
+			fv.Set(reflect.ValueOf(files))
+			for _, file := range files {
+				b.bound[file] = true
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// isEmbeddedStruct checks if the type of an embedded field is a struct
+// or struct pointer to descend into.
+func isEmbeddedStruct(rt reflect.Type) bool {
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	return rt.Kind() == reflect.Struct && !isScalarType(rt)
+}
+
+// isScalarType checks if the struct type is bound as one value.
+func isScalarType(rt reflect.Type) bool {
+	return rt == timeType || reflect.PtrTo(rt).Implements(textUnmarshalType)
+}
+
+// bindField sets the field to the values. Slices get all values, all
+// other types the first one.
+func bindField(fv reflect.Value, vs []string) error {
+	if fv.Kind() == reflect.Slice && !fv.Addr().Type().Implements(textUnmarshalType) {
+		sv := reflect.MakeSlice(fv.Type(), len(vs), len(vs))
+		for i, v := range vs {
+			if err := bindScalar(sv.Index(i), v); err != nil {
+				return err
+			}
+		}
+		fv.Set(sv)
+		return nil
+	}
+	return bindScalar(fv, vs[0])
+}
+
+// bindScalar parses the string and sets the value.
+func bindScalar(fv reflect.Value, s string) error {
+	if fv.Kind() == reflect.Ptr {
+		pv := reflect.New(fv.Type().Elem())
+		if err := bindScalar(pv.Elem(), s); err != nil {
+			return err
+		}
+		fv.Set(pv)
+		return nil
+	}
+	switch fv.Type() {
+	case timeType:
+		t, err := parseTime(s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	if tu, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(s))
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		if s == "" {
+			fv.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// parseTime parses a time in RFC 3339 format or a date.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %q is neither RFC 3339 nor a date", s)
+	}
+	return t, nil
+}
+
+// EOF
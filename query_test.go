@@ -0,0 +1,126 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestReadQuery verifies the binding of query values to struct fields.
+func TestReadQuery(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	var f filter
+	req := httptest.NewRequest(http.MethodGet,
+		"/orders?limit=10&offset=20&active&since=2022-03-01&timeout=1m30s"+
+			"&tag=a&tag=b&id=1&id=2&id=3&ratio=0.5&Name=test&ignored=x&sort=-date", nil)
+	err := httpx.ReadQuery(req, &f)
+	assert.NoError(err)
+	assert.Equal(f.Limit, 10)
+	assert.Equal(f.Offset, uint(20))
+	assert.True(f.Active)
+	assert.Equal(f.Since, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(f.Timeout, 90*time.Second)
+	assert.Equal(f.Tags, []string{"a", "b"})
+	assert.Equal(f.IDs, []int64{1, 2, 3})
+	assert.NotNil(f.Ratio)
+	assert.Equal(*f.Ratio, 0.5)
+	assert.Equal(f.Name, "test")
+	assert.Equal(f.Ignored, "")
+	assert.NotNil(f.Sorting)
+	assert.Equal(f.Sort, "-date")
+
+	// Missing keys keep the current values.
+	f = filter{Paging: Paging{Limit: 25}}
+	req = httptest.NewRequest(http.MethodGet, "/orders?active=false", nil)
+	err = httpx.ReadQuery(req, &f)
+	assert.NoError(err)
+	assert.Equal(f.Limit, 25)
+	assert.False(f.Active)
+	assert.Nil(f.Ratio)
+	assert.Nil(f.Sorting)
+}
+
+// TestReadQueryErrors verifies the reporting of invalid query values.
+func TestReadQueryErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	var f filter
+	req := httptest.NewRequest(http.MethodGet,
+		"/orders?limit=ten&offset=-1&since=yesterday&id=1&id=x&Name=ok", nil)
+	err := httpx.ReadQuery(req, &f)
+	assert.ErrorContains(err, "ReadQuery: cannot bind fields")
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusBadRequest)
+
+	var ferrs httpx.FieldErrors
+	assert.True(errors.As(err, &ferrs))
+	assert.Length(ferrs, 4)
+	fields := []string{}
+	keys := []string{}
+	for _, ferr := range ferrs {
+		fields = append(fields, ferr.Field)
+		keys = append(keys, ferr.Key)
+	}
+	assert.Equal(fields, []string{"Paging.Limit", "Paging.Offset", "Since", "IDs"})
+	assert.Equal(keys, []string{"limit", "offset", "since", "id"})
+	assert.Equal(ferrs[3].Value, "1,x")
+	assert.Equal(f.Name, "ok")
+
+	var s string
+	err = httpx.ReadQuery(req, &s)
+	assert.ErrorContains(err, "ReadQuery: value is not a struct pointer")
+	err = httpx.ReadQuery(req, f)
+	assert.ErrorContains(err, "ReadQuery: value is not a struct pointer")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// Paging is embedded into the filter.
+type Paging struct {
+	Limit  int  `query:"limit"`
+	Offset uint `query:"offset"`
+}
+
+// Sorting is embedded into the filter as pointer.
+type Sorting struct {
+	Sort string `query:"sort"`
+}
+
+// filter is the target of the query binding tests.
+type filter struct {
+	Paging
+	*Sorting
+	Active  bool          `query:"active"`
+	Since   time.Time     `query:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Tags    []string      `query:"tag"`
+	IDs     []int64       `query:"id"`
+	Ratio   *float64      `query:"ratio"`
+	Name    string
+	Ignored string `query:"-"`
+}
+
+// EOF
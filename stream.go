@@ -0,0 +1,507 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	ContentTypeCSV    = "text/csv"
+	ContentTypeNDJSON = "application/x-ndjson"
+)
+
+//--------------------
+// BODY ITERATOR
+//--------------------
+
+// BodyIterator decodes the elements of a request body one at a time. The body
+// has to be newline delimited JSON or a JSON array. It's used like
+//
+//	it, err := httpx.NewBodyIterator(r, httpx.MaxBodySize(1 << 30))
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//	    var record Record
+//	    if err := it.Decode(&record); err != nil {
+//	        ...
+//	    }
+//	}
+//	if err := it.Err(); err != nil {
+//	    ...
+//	}
+type BodyIterator struct {
+	body    *bodyReader
+	codec   JSONCodec
+	index   int
+	lines   *bufio.Reader
+	line    []byte
+	decoder *json.Decoder
+	pending bool
+	done    bool
+	err     error
+}
+
+// NewBodyIterator creates an iterator over the elements of the request body.
+// The content type application/x-ndjson is read line by line, JSON types have
+// to contain an array. The read options are applied to the whole body and
+// each element.
+func NewBodyIterator(r *http.Request, options ...ReadOption) (*BodyIterator, error) {
+	opts := newReadOptions(options)
+	mediaType, _, err := parseContentType(r.Header.Get(HeaderContentType))
+	if err != nil {
+		return nil, newBodyError(BodyErrorMediaType, "%w", err)
+	}
+	isNDJSON := mediaType == ContentTypeNDJSON
+	if !isNDJSON && !(mediaType == ContentTypeJSON || strings.HasSuffix(mediaType, "+json")) {
+		_ = r.Body.Close()
+		return nil, newBodyError(BodyErrorMediaType, "cannot iterate over %q", mediaType)
+	}
+	body, err := openBody(r, opts.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	it := &BodyIterator{
+		body:  body,
+		codec: opts.apply(JSONCodec{}).(JSONCodec),
+		index: -1,
+	}
+	if isNDJSON {
+		it.lines = bufio.NewReader(body)
+		return it, nil
+	}
+	it.decoder = json.NewDecoder(body)
+	if opts.disallowUnknownFields {
+		it.decoder.DisallowUnknownFields()
+	}
+	if opts.useNumber {
+		it.decoder.UseNumber()
+	}
+	token, err := it.decoder.Token()
+	if err != nil {
+		_ = body.Close()
+		return nil, it.bodyError(err, "cannot read array")
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		_ = body.Close()
+		return nil, newBodyError(BodyErrorSyntax, "body is no JSON array")
+	}
+	return it, nil
+}
+
+// Next moves to the next element. It returns false at the end of the body
+// or in case of an error.
+func (it *BodyIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if it.lines != nil {
+		return it.nextLine()
+	}
+	if it.pending {
+		// Skip the element not decoded.
+		var skip json.RawMessage
+		if err := it.decoder.Decode(&skip); err != nil {
+			return it.fail(it.bodyError(err, "cannot skip element %d", it.index))
+		}
+	}
+	if !it.decoder.More() {
+		if _, err := it.decoder.Token(); err != nil {
+			return it.fail(it.bodyError(err, "cannot read array end"))
+		}
+		if err := checkJSONEnd(it.decoder, "array"); err != nil {
+			return it.fail(it.bodyError(err, "cannot read array end"))
+		}
+		it.done = true
+		return false
+	}
+	it.index++
+	it.pending = true
+	return true
+}
+
+// Decode decodes the current element into the value.
+func (it *BodyIterator) Decode(value interface{}) error {
+	if it.done || it.index < 0 {
+		return errors.New("BodyIterator: no current element")
+	}
+	if it.lines != nil {
+		if err := it.codec.Decode(bytes.NewReader(it.line), value); err != nil {
+			return newBodyError(decodeErrorKind(err), "cannot unmarshal element %d: %w", it.index, err)
+		}
+		return nil
+	}
+	if !it.pending {
+		return errors.New("BodyIterator: element already decoded")
+	}
+	it.pending = false
+	if err := it.decoder.Decode(value); err != nil {
+		err = it.bodyError(jsonDecodeError(err), "cannot unmarshal element %d", it.index)
+		var be *BodyError
+		if errors.As(err, &be) && be.Kind != BodyErrorType {
+			// The stream cannot be continued.
+			it.fail(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Index returns the index of the current element starting with 0.
+func (it *BodyIterator) Index() int {
+	return it.index
+}
+
+// Err returns the error stopping the iteration.
+func (it *BodyIterator) Err() error {
+	return it.err
+}
+
+// Close closes the body.
+func (it *BodyIterator) Close() error {
+	it.done = true
+	return it.body.Close()
+}
+
+// nextLine reads the next non-empty line of NDJSON.
+func (it *BodyIterator) nextLine() bool {
+	for {
+		line, err := it.lines.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return it.fail(it.bodyError(err, "cannot read line"))
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			it.index++
+			it.line = line
+			return true
+		}
+		if err == io.EOF {
+			it.done = true
+			return false
+		}
+	}
+}
+
+// fail stops the iteration with the error.
+func (it *BodyIterator) fail(err error) bool {
+	it.err = err
+	it.done = true
+	return false
+}
+
+// bodyError keeps read errors of the body and classifies decoding errors.
+func (it *BodyIterator) bodyError(err error, format string, args ...interface{}) error {
+	var be *BodyError
+	if errors.As(err, &be) && (be.Kind == BodyErrorRead || be.Kind == BodyErrorTooLarge) {
+		return be
+	}
+	return newBodyError(decodeErrorKind(err), format+": %w", append(args, err)...)
+}
+
+//--------------------
+// STREAM WRITER
+//--------------------
+
+// StreamFormat defines how the values of a stream are written.
+type StreamFormat int
+
+// Formats of streams.
+const (
+	StreamNDJSON StreamFormat = iota
+	StreamJSONArray
+	StreamCSV
+)
+
+// StreamWriterConfig allows to control how the stream writer works.
+// Default values are:
+//   - Format:     StreamNDJSON
+//   - FlushEvery: 100
+//   - Header:     CSV header derived from the first struct value
+//   - Record:     CSV record of string slices or struct fields
+type StreamWriterConfig struct {
+	// Format is the format of the written values.
+	Format StreamFormat
+
+	// FlushEvery is the number of values after which the response
+	// is flushed.
+	FlushEvery int
+
+	// Header is the header line of CSV streams.
+	Header []string
+
+	// Record converts a value into a CSV record.
+	Record func(value interface{}) ([]string, error)
+}
+
+// StreamWriter writes values one at a time as NDJSON, as JSON array, or
+// as CSV rows to a response. It stops when the context of the request
+// is cancelled.
+type StreamWriter struct {
+	w          http.ResponseWriter
+	ctx        context.Context
+	format     StreamFormat
+	flushEvery int
+	header     []string
+	record     func(value interface{}) ([]string, error)
+	csv        *csv.Writer
+	count      int
+	started    bool
+	closed     bool
+}
+
+// NewStreamWriter creates a stream writer for the response of the request.
+func NewStreamWriter(w http.ResponseWriter, r *http.Request, cfg *StreamWriterConfig) *StreamWriter {
+	if cfg == nil {
+		cfg = &StreamWriterConfig{}
+	}
+	sw := &StreamWriter{
+		w:          w,
+		ctx:        r.Context(),
+		format:     cfg.Format,
+		flushEvery: cfg.FlushEvery,
+		header:     cfg.Header,
+		record:     cfg.Record,
+	}
+	if sw.flushEvery <= 0 {
+		sw.flushEvery = 100
+	}
+	if sw.record == nil {
+		sw.record = csvRecord
+	}
+	return sw
+}
+
+// Write writes one value. It returns the error of the request context
+// if it is cancelled.
+func (sw *StreamWriter) Write(value interface{}) error {
+	if sw.closed {
+		return errors.New("StreamWriter: writer is closed")
+	}
+	if err := sw.ctx.Err(); err != nil {
+		return err
+	}
+	if err := sw.start(value); err != nil {
+		return err
+	}
+	var err error
+	switch sw.format {
+	case StreamCSV:
+		var record []string
+		record, err = sw.record(value)
+		if err != nil {
+			return fmt.Errorf("StreamWriter: cannot convert value into record: %v", err)
+		}
+		if err = sw.csv.Write(record); err == nil {
+			sw.csv.Flush()
+			err = sw.csv.Error()
+		}
+	default:
+		var data []byte
+		data, err = json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("StreamWriter: cannot marshal value: %v", err)
+		}
+		switch {
+		case sw.format == StreamNDJSON:
+			data = append(data, '\n')
+		case sw.count > 0:
+			data = append([]byte{','}, data...)
+		}
+		_, err = sw.w.Write(data)
+	}
+	if err != nil {
+		return fmt.Errorf("StreamWriter: cannot write value: %v", err)
+	}
+	sw.count++
+	if sw.count%sw.flushEvery == 0 {
+		sw.flush()
+	}
+	return nil
+}
+
+// Count returns the number of written values.
+func (sw *StreamWriter) Count() int {
+	return sw.count
+}
+
+// Close finishes the stream and flushes the response. A cancelled
+// stream is not finished.
+func (sw *StreamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	if err := sw.ctx.Err(); err != nil {
+		return err
+	}
+	if err := sw.start(nil); err != nil {
+		return err
+	}
+	if sw.format == StreamJSONArray {
+		if _, err := sw.w.Write([]byte("]\n")); err != nil {
+			return fmt.Errorf("StreamWriter: cannot write value: %v", err)
+		}
+	}
+	sw.flush()
+	return nil
+}
+
+// start writes the content type and the beginning of the stream
+// before the first value.
+func (sw *StreamWriter) start(value interface{}) error {
+	if sw.started {
+		return nil
+	}
+	sw.started = true
+	switch sw.format {
+	case StreamCSV:
+		sw.w.Header().Set(HeaderContentType, ContentTypeCSV)
+		sw.csv = csv.NewWriter(sw.w)
+		header := sw.header
+		if header == nil && value != nil {
+			header = csvHeader(value)
+		}
+		if header != nil {
+			if err := sw.csv.Write(header); err != nil {
+				return fmt.Errorf("StreamWriter: cannot write header: %v", err)
+			}
+			sw.csv.Flush()
+		}
+	case StreamJSONArray:
+		sw.w.Header().Set(HeaderContentType, ContentTypeJSON)
+		if _, err := sw.w.Write([]byte("[")); err != nil {
+			return fmt.Errorf("StreamWriter: cannot write value: %v", err)
+		}
+	default:
+		sw.w.Header().Set(HeaderContentType, ContentTypeNDJSON)
+	}
+	return nil
+}
+
+// flush flushes the response if possible.
+func (sw *StreamWriter) flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// StreamChannel writes all values received from the channel until it is
+// closed or the request context is cancelled. The channel can be of any
+// element type. It returns the number of written values.
+func StreamChannel(w http.ResponseWriter, r *http.Request, cfg *StreamWriterConfig, channel interface{}) (int, error) {
+	cv := reflect.ValueOf(channel)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&reflect.RecvDir == 0 {
+		return 0, fmt.Errorf("StreamChannel: value is no receivable channel")
+	}
+	sw := NewStreamWriter(w, r, cfg)
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+		{Dir: reflect.SelectRecv, Chan: cv},
+	}
+	for {
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 0 {
+			return sw.Count(), r.Context().Err()
+		}
+		if !ok {
+			return sw.Count(), sw.Close()
+		}
+		if err := sw.Write(value.Interface()); err != nil {
+			return sw.Count(), err
+		}
+	}
+}
+
+// StreamFunc writes all values returned by next until it returns io.EOF
+// or the request context is cancelled. It returns the number of written
+// values.
+func StreamFunc(w http.ResponseWriter, r *http.Request, cfg *StreamWriterConfig, next func() (interface{}, error)) (int, error) {
+	sw := NewStreamWriter(w, r, cfg)
+	for {
+		value, err := next()
+		if err == io.EOF {
+			return sw.Count(), sw.Close()
+		}
+		if err != nil {
+			return sw.Count(), err
+		}
+		if err := sw.Write(value); err != nil {
+			return sw.Count(), err
+		}
+	}
+}
+
+// csvFields returns the exported fields of a struct value and their
+// names taken from the csv tag or the field name.
+func csvFields(value interface{}) (reflect.Value, []int, []string) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if rv.Kind() != reflect.Struct {
+		return rv, nil, nil
+	}
+	var indices []int
+	var names []string
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get("csv")
+		if sf.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = sf.Name
+		}
+		indices = append(indices, i)
+		names = append(names, name)
+	}
+	return rv, indices, names
+}
+
+// csvHeader derives the CSV header from a struct value.
+func csvHeader(value interface{}) []string {
+	_, _, names := csvFields(value)
+	return names
+}
+
+// csvRecord is the default conversion of values into CSV records. It
+// accepts string slices and structs.
+func csvRecord(value interface{}) ([]string, error) {
+	if record, ok := value.([]string); ok {
+		return record, nil
+	}
+	rv, indices, _ := csvFields(value)
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("value of type %T is neither string slice nor struct", value)
+	}
+	record := make([]string, len(indices))
+	for i, index := range indices {
+		record[i] = fmt.Sprint(rv.Field(index).Interface())
+	}
+	return record, nil
+}
+
+// EOF
@@ -0,0 +1,455 @@
+// Tideland Go HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBodyIterator verifies the iteration over NDJSON and JSON arrays.
+func TestBodyIterator(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "NDJSON",
+			contentType: httpx.ContentTypeNDJSON,
+			body:        "{\"first_string\":\"a\",\"second_int\":1}\n\n{\"first_string\":\"b\",\"second_int\":2}\r\n{\"first_string\":\"c\",\"second_int\":3}",
+		}, {
+			name:        "JSON array",
+			contentType: httpx.ContentTypeJSON,
+			body:        `[{"first_string":"a","second_int":1}, {"first_string":"b","second_int":2},{"first_string":"c","second_int":3}]`,
+		}, {
+			name:        "JSON array with suffix",
+			contentType: "application/vnd.records+json; charset=utf-8",
+			body:        "[\n{\"first_string\":\"a\",\"second_int\":1},\n{\"first_string\":\"b\",\"second_int\":2},\n{\"first_string\":\"c\",\"second_int\":3}\n]\n",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set(httpx.HeaderContentType, test.contentType)
+		it, err := httpx.NewBodyIterator(req)
+		assert.NoError(err)
+		var bodies []body
+		for it.Next() {
+			var b body
+			assert.NoError(it.Decode(&b))
+			assert.Equal(it.Index(), len(bodies))
+			bodies = append(bodies, b)
+		}
+		assert.NoError(it.Err())
+		assert.NoError(it.Close())
+		assert.Equal(bodies, []body{{"a", 1}, {"b", 2}, {"c", 3}})
+		assert.False(it.Next())
+	}
+}
+
+// TestBodyIteratorSkipping verifies the skipping of elements not decoded
+// and the continuation after type errors.
+func TestBodyIteratorSkipping(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	for _, contentType := range []string{httpx.ContentTypeJSON, httpx.ContentTypeNDJSON} {
+		assert.Logf("content type %s", contentType)
+		records := []string{
+			`{"first_string":"a","second_int":1}`,
+			`{"first_string":"b","second_int":"two"}`,
+			`{"first_string":"c","second_int":3}`,
+			`{"first_string":"d","second_int":4}`,
+		}
+		content := strings.Join(records, "\n")
+		if contentType == httpx.ContentTypeJSON {
+			content = "[" + strings.Join(records, ",") + "]"
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(content))
+		req.Header.Set(httpx.HeaderContentType, contentType)
+		it, err := httpx.NewBodyIterator(req)
+		assert.NoError(err)
+
+		var decoded []string
+		for it.Next() {
+			if it.Index() == 2 {
+				continue
+			}
+			var b body
+			err := it.Decode(&b)
+			if it.Index() == 1 {
+				var berr *httpx.BodyError
+				assert.True(errors.As(err, &berr))
+				assert.Equal(berr.Kind, httpx.BodyErrorType)
+				assert.ErrorContains(err, "cannot unmarshal element 1")
+				continue
+			}
+			assert.NoError(err)
+			decoded = append(decoded, b.FirstString)
+		}
+		assert.NoError(it.Err())
+		assert.Equal(decoded, []string{"a", "d"})
+	}
+}
+
+// TestBodyIteratorStrict verifies that unknown fields in strict mode are
+// type errors of single elements.
+func TestBodyIteratorStrict(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	for _, contentType := range []string{httpx.ContentTypeJSON, httpx.ContentTypeNDJSON} {
+		assert.Logf("content type %s", contentType)
+		records := []string{
+			`{"first_string":"a"}`,
+			`{"first_string":"b","third":3}`,
+			`{"first_string":"c"}`,
+		}
+		content := strings.Join(records, "\n")
+		if contentType == httpx.ContentTypeJSON {
+			content = "[" + strings.Join(records, ",") + "]"
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(content))
+		req.Header.Set(httpx.HeaderContentType, contentType)
+		it, err := httpx.NewBodyIterator(req, httpx.StrictJSON())
+		assert.NoError(err)
+
+		var decoded []string
+		for it.Next() {
+			var b body
+			err := it.Decode(&b)
+			if it.Index() == 1 {
+				assert.ErrorContains(err, `ReadBody: cannot unmarshal element 1: json: unknown field "third"`)
+				var berr *httpx.BodyError
+				assert.True(errors.As(err, &berr))
+				assert.Equal(berr.Kind, httpx.BodyErrorType)
+				assert.Equal(httpx.ErrorStatusCode(err), http.StatusUnprocessableEntity)
+				continue
+			}
+			assert.NoError(err)
+			decoded = append(decoded, b.FirstString)
+		}
+		assert.NoError(it.Err())
+		assert.Equal(decoded, []string{"a", "c"})
+	}
+}
+
+// TestBodyIteratorErrors verifies the errors of iterations.
+func TestBodyIteratorErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	// Invalid bodies.
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		options     []httpx.ReadOption
+		createErr   string
+		iterateErr  string
+		kind        httpx.BodyErrorKind
+	}{
+		{
+			name:        "XML",
+			contentType: httpx.ContentTypeXML,
+			body:        "<root/>",
+			createErr:   `ReadBody: cannot iterate over "application/xml"`,
+			kind:        httpx.BodyErrorMediaType,
+		}, {
+			name:        "no array",
+			contentType: httpx.ContentTypeJSON,
+			body:        `{"first_string":"a"}`,
+			createErr:   "ReadBody: body is no JSON array",
+			kind:        httpx.BodyErrorSyntax,
+		}, {
+			name:        "broken array",
+			contentType: httpx.ContentTypeJSON,
+			body:        `[{"first_string":"a"},{"first_string":`,
+			iterateErr:  "ReadBody: cannot unmarshal element 1",
+			kind:        httpx.BodyErrorSyntax,
+		}, {
+			name:        "trailing data",
+			contentType: httpx.ContentTypeJSON,
+			body:        `[{"first_string":"a"}] []`,
+			iterateErr:  "ReadBody: cannot read array end: json: unexpected data after array",
+			kind:        httpx.BodyErrorSyntax,
+		}, {
+			name:        "too large array",
+			contentType: httpx.ContentTypeJSON,
+			body:        `[{"first_string":"a"},{"first_string":"b"},{"first_string":"c"}]`,
+			options:     []httpx.ReadOption{httpx.MaxBodySize(30)},
+			iterateErr:  "ReadBody: body exceeds limit of 30 bytes",
+			kind:        httpx.BodyErrorTooLarge,
+		}, {
+			name:        "too large NDJSON",
+			contentType: httpx.ContentTypeNDJSON,
+			body:        "{\"first_string\":\"a\"}\n{\"first_string\":\"b\"}\n{\"first_string\":\"c\"}\n",
+			options:     []httpx.ReadOption{httpx.MaxBodySize(30)},
+			iterateErr:  "ReadBody: body exceeds limit of 30 bytes",
+			kind:        httpx.BodyErrorTooLarge,
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set(httpx.HeaderContentType, test.contentType)
+		req.ContentLength = -1
+		it, err := httpx.NewBodyIterator(req, test.options...)
+		if test.createErr != "" {
+			assert.ErrorContains(err, test.createErr)
+			var berr *httpx.BodyError
+			assert.True(errors.As(err, &berr))
+			assert.Equal(berr.Kind, test.kind)
+			continue
+		}
+		assert.NoError(err)
+		for it.Next() {
+			var b body
+			if err := it.Decode(&b); err != nil {
+				assert.ErrorContains(err, test.iterateErr)
+				break
+			}
+		}
+		for it.Next() {
+		}
+		err = it.Err()
+		assert.ErrorContains(err, test.iterateErr)
+		var berr *httpx.BodyError
+		assert.True(errors.As(err, &berr))
+		assert.Equal(berr.Kind, test.kind)
+	}
+}
+
+// TestReadBodyStreaming verifies that ReadBody decodes while reading.
+func TestReadBodyStreaming(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	// The value is decoded, the failure is noticed when checking its end.
+	req := httptest.NewRequest(http.MethodPost, "/", &failingReader{
+		data: `{"first_string":"valid","second_int":1}`,
+	})
+	req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
+	var b body
+	err := httpx.ReadBody(req, &b)
+	assert.ErrorContains(err, "ReadBody: cannot read body: connection lost")
+	assert.Equal(b, body{FirstString: "valid", SecondInt: 1})
+
+	req = httptest.NewRequest(http.MethodPost, "/", &failingReader{
+		data: `{"first_string":"valid",`,
+	})
+	req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
+	err = httpx.ReadBody(req, &b)
+	assert.ErrorContains(err, "ReadBody: cannot read body: connection lost")
+	var berr *httpx.BodyError
+	assert.True(errors.As(err, &berr))
+	assert.Equal(berr.Kind, httpx.BodyErrorRead)
+}
+
+// TestStreamWriter verifies the writing of streams in the different formats.
+func TestStreamWriter(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	values := []body{{"a", 1}, {"b", 2}, {"c,d", 3}}
+
+	tests := []struct {
+		name        string
+		cfg         *httpx.StreamWriterConfig
+		values      []interface{}
+		contentType string
+		body        string
+		flushes     int
+	}{
+		{
+			name:        "NDJSON",
+			cfg:         nil,
+			values:      []interface{}{values[0], values[1], values[2]},
+			contentType: httpx.ContentTypeNDJSON,
+			body:        "{\"first_string\":\"a\",\"second_int\":1}\n{\"first_string\":\"b\",\"second_int\":2}\n{\"first_string\":\"c,d\",\"second_int\":3}\n",
+			flushes:     1,
+		}, {
+			name:        "JSON array",
+			cfg:         &httpx.StreamWriterConfig{Format: httpx.StreamJSONArray, FlushEvery: 1},
+			values:      []interface{}{values[0], values[1], values[2]},
+			contentType: httpx.ContentTypeJSON,
+			body:        "[{\"first_string\":\"a\",\"second_int\":1},{\"first_string\":\"b\",\"second_int\":2},{\"first_string\":\"c,d\",\"second_int\":3}]\n",
+			flushes:     4,
+		}, {
+			name:        "empty JSON array",
+			cfg:         &httpx.StreamWriterConfig{Format: httpx.StreamJSONArray},
+			contentType: httpx.ContentTypeJSON,
+			body:        "[]\n",
+			flushes:     1,
+		}, {
+			name:        "CSV with derived header",
+			cfg:         &httpx.StreamWriterConfig{Format: httpx.StreamCSV, FlushEvery: 2},
+			values:      []interface{}{values[0], values[1], &values[2]},
+			contentType: httpx.ContentTypeCSV,
+			body:        "FirstString,SecondInt\na,1\nb,2\n\"c,d\",3\n",
+			flushes:     2,
+		}, {
+			name: "CSV with header and records",
+			cfg: &httpx.StreamWriterConfig{
+				Format: httpx.StreamCSV,
+				Header: []string{"name", "value"},
+			},
+			values:      []interface{}{[]string{"a", "1"}, []string{"b", "2"}},
+			contentType: httpx.ContentTypeCSV,
+			body:        "name,value\na,1\nb,2\n",
+			flushes:     1,
+		}, {
+			name: "CSV with record function",
+			cfg: &httpx.StreamWriterConfig{
+				Format: httpx.StreamCSV,
+				Record: func(value interface{}) ([]string, error) {
+					return []string{strings.ToUpper(value.(string))}, nil
+				},
+			},
+			values:      []interface{}{"a", "b"},
+			contentType: httpx.ContentTypeCSV,
+			body:        "A\nB\n",
+			flushes:     1,
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.name)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		next := 0
+		n, err := httpx.StreamFunc(w, req, test.cfg, func() (interface{}, error) {
+			if next == len(test.values) {
+				return nil, io.EOF
+			}
+			next++
+			return test.values[next-1], nil
+		})
+		assert.NoError(err)
+		assert.Equal(n, len(test.values))
+		assert.Equal(w.Header().Get(httpx.HeaderContentType), test.contentType)
+		assert.Equal(w.Body.String(), test.body)
+		assert.Equal(w.flushes, test.flushes)
+	}
+}
+
+// TestStreamChannel verifies the writing of values received from a channel.
+func TestStreamChannel(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	// Complete stream.
+	bodies := make(chan body, 3)
+	bodies <- body{"a", 1}
+	bodies <- body{"b", 2}
+	close(bodies)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	n, err := httpx.StreamChannel(w, req, &httpx.StreamWriterConfig{Format: httpx.StreamJSONArray}, bodies)
+	assert.NoError(err)
+	assert.Equal(n, 2)
+	assert.Equal(w.Body.String(), `[{"first_string":"a","second_int":1},{"first_string":"b","second_int":2}]`+"\n")
+
+	// Cancelled stream.
+	ctx, cancel := context.WithCancel(context.Background())
+	ints := make(chan int, 3)
+	ints <- 1
+	ints <- 2
+	ints <- 3
+	req = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	cw := &cancelRecorder{ResponseRecorder: w, cancel: cancel, after: ",2"}
+	n, err = httpx.StreamChannel(cw, req, &httpx.StreamWriterConfig{Format: httpx.StreamJSONArray}, ints)
+	assert.True(errors.Is(err, context.Canceled))
+	assert.Equal(n, 2)
+	assert.Equal(w.Body.String(), "[1,2")
+
+	// Invalid channel.
+	_, err = httpx.StreamChannel(w, req, nil, []int{1, 2})
+	assert.ErrorContains(err, "StreamChannel: value is no receivable channel")
+}
+
+// TestStreamWriterCancel verifies the stopping of stream writers.
+func TestStreamWriterCancel(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	sw := httpx.NewStreamWriter(w, req, nil)
+	assert.NoError(sw.Write(1))
+	cancel()
+	assert.True(errors.Is(sw.Write(2), context.Canceled))
+	assert.True(errors.Is(sw.Close(), context.Canceled))
+	assert.Equal(sw.Count(), 1)
+	assert.Equal(w.Body.String(), "1\n")
+	assert.ErrorContains(sw.Write(3), "StreamWriter: writer is closed")
+
+	// Values not convertible into records.
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	sw = httpx.NewStreamWriter(httptest.NewRecorder(), req, &httpx.StreamWriterConfig{Format: httpx.StreamCSV})
+	assert.ErrorContains(sw.Write(42), "StreamWriter: cannot convert value into record")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// flushRecorder counts the flushes of the response.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (fr *flushRecorder) Flush() {
+	fr.flushes++
+	fr.ResponseRecorder.Flush()
+}
+
+// cancelRecorder cancels a context after writing the given data.
+type cancelRecorder struct {
+	*httptest.ResponseRecorder
+	cancel func()
+	after  string
+}
+
+func (cr *cancelRecorder) Write(data []byte) (int, error) {
+	n, err := cr.ResponseRecorder.Write(data)
+	if string(data) == cr.after {
+		cr.cancel()
+	}
+	return n, err
+}
+
+// failingReader returns its data and then fails.
+type failingReader struct {
+	data string
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.data == "" {
+		return 0, errors.New("connection lost")
+	}
+	n := copy(p, fr.data)
+	fr.data = fr.data[n:]
+	return n, nil
+}
+
+// EOF
@@ -0,0 +1,424 @@
+// Tideland Go HTTP Extensions
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//--------------------
+// ROUTE
+//--------------------
+
+// route is a handler registered at the nested multiplexer. The handler
+// is kept for introspection, requests are served by the wrapped one.
+type route struct {
+	path     string
+	resource bool
+	pattern  *pattern
+	handler  http.Handler
+	wrappers []func(h http.Handler) http.Handler
+	serve    http.Handler
+	mounted  *NestedMux
+}
+
+// newRoute creates a route for a resource path or a path pattern.
+func newRoute(path string, h http.Handler, wrappers []func(h http.Handler) http.Handler) (*route, error) {
+	rt := &route{
+		path:     path,
+		handler:  h,
+		wrappers: wrappers,
+		serve:    wrap(h, wrappers),
+	}
+	if !isPattern(path) {
+		rt.resource = true
+		rt.pattern = resourcePattern(path)
+		return rt, nil
+	}
+	p, err := parsePattern(path)
+	if err != nil {
+		return nil, err
+	}
+	rt.pattern = p
+	return rt, nil
+}
+
+// newMountRoute creates a route passing all requests for the path and
+// below to the mounted multiplexer.
+func newMountRoute(path string, sub *NestedMux, wrappers []func(h http.Handler) http.Handler) *route {
+	p := &pattern{
+		raw: "/" + path + "/...",
+	}
+	for _, name := range strings.Split(path, "/") {
+		p.segments = append(p.segments, segment{kind: staticSegment, value: name})
+	}
+	p.segments = append(p.segments, segment{kind: catchAllSegment})
+	return &route{
+		path:     p.raw,
+		pattern:  p,
+		handler:  sub,
+		wrappers: wrappers,
+		serve:    wrap(sub, wrappers),
+		mounted:  sub,
+	}
+}
+
+// variants returns the token sequences to insert into the routing tree for
+// the route together with their leaves. Resource paths may end with or
+// without ID and with a trailing slash. Patterns ending with a catch-all
+// parameter also match the path without it.
+func (rt *route) variants() ([][]token, []*leaf) {
+	segs := rt.pattern.segments
+	if rt.resource {
+		if rt.path == "" {
+			return [][]token{tokenize(segs)}, []*leaf{{route: rt}}
+		}
+		withID := append(append([]segment{}, segs...), segment{kind: paramSegment, empty: true})
+		withSlash := append(append([]segment{}, withID...), segment{kind: staticSegment})
+		return [][]token{tokenize(segs), tokenize(withID), tokenize(withSlash)},
+			[]*leaf{{route: rt}, {route: rt}, {route: rt}}
+	}
+	last := segs[len(segs)-1]
+	if last.kind != catchAllSegment {
+		return [][]token{tokenize(segs)}, []*leaf{{route: rt}}
+	}
+	return [][]token{tokenize(segs), tokenize(segs[:len(segs)-1])},
+		[]*leaf{{route: rt}, {route: rt, fill: last.value}}
+}
+
+//--------------------
+// TOKENS
+//--------------------
+
+// token is a part of a path inserted into the routing tree. Static tokens
+// contain the text of neighboring static segments including the slashes.
+type token struct {
+	kind  segmentKind
+	text  string
+	typ   string
+	check func(value string) bool
+	empty bool
+}
+
+// tokenize converts pattern segments into tokens.
+func tokenize(segs []segment) []token {
+	var tokens []token
+	var static string
+	for i, seg := range segs {
+		if i > 0 {
+			static += "/"
+		}
+		if seg.kind == staticSegment {
+			static += seg.value
+			continue
+		}
+		if static != "" {
+			tokens = append(tokens, token{kind: staticSegment, text: static})
+			static = ""
+		}
+		tokens = append(tokens, token{
+			kind:  seg.kind,
+			text:  seg.value,
+			typ:   seg.typ,
+			check: seg.check,
+			empty: seg.empty,
+		})
+	}
+	if static != "" {
+		tokens = append(tokens, token{kind: staticSegment, text: static})
+	}
+	return tokens
+}
+
+//--------------------
+// ROUTING TREE
+//--------------------
+
+// leaf terminates a path in the routing tree.
+type leaf struct {
+	route *route
+	fill  string
+}
+
+// paramValue is a matched parameter.
+type paramValue struct {
+	name  string
+	value string
+}
+
+// node is a node of the compressed radix tree used for routing. Static
+// nodes match their prefix, parameter nodes the path up to the next slash,
+// and catch-all nodes the rest of the path. Nodes are never changed after
+// being published, inserting copies the nodes along the path.
+type node struct {
+	kind     segmentKind
+	prefix   string
+	name     string
+	typ      string
+	check    func(value string) bool
+	empty    bool
+	statics  []*node
+	params   []*node
+	catchAll *node
+	leaf     *leaf
+}
+
+// clone returns a shallow copy of the node.
+func (n *node) clone() *node {
+	c := *n
+	return &c
+}
+
+// insert returns a copy of the node with the tokens inserted below it.
+func (n *node) insert(tokens []token, l *leaf) *node {
+	c := n.clone()
+	if len(tokens) == 0 {
+		c.leaf = l
+		return c
+	}
+	t := tokens[0]
+	switch t.kind {
+	case staticSegment:
+		c.statics = c.insertStatic(t.text, tokens[1:], l)
+	case paramSegment:
+		c.params = c.insertParam(t, tokens[1:], l)
+	case catchAllSegment:
+		c.catchAll = &node{
+			kind: catchAllSegment,
+			name: t.text,
+			leaf: l,
+		}
+	}
+	return c
+}
+
+// insertStatic returns a copy of the static children with the static
+// text and the following tokens inserted. Children sharing a prefix
+// with the text are split.
+func (n *node) insertStatic(text string, tokens []token, l *leaf) []*node {
+	statics := append([]*node{}, n.statics...)
+	for i, child := range statics {
+		cp := commonPrefixLen(child.prefix, text)
+		if cp == 0 {
+			continue
+		}
+		if cp < len(child.prefix) {
+			tail := child.clone()
+			tail.prefix = child.prefix[cp:]
+			child = &node{
+				kind:    staticSegment,
+				prefix:  child.prefix[:cp],
+				statics: []*node{tail},
+			}
+		}
+		if cp == len(text) {
+			statics[i] = child.insert(tokens, l)
+		} else {
+			c := child.clone()
+			c.statics = child.insertStatic(text[cp:], tokens, l)
+			statics[i] = c
+		}
+		return statics
+	}
+	child := &node{
+		kind:   staticSegment,
+		prefix: text,
+	}
+	return append(statics, child.insert(tokens, l))
+}
+
+// insertParam returns a copy of the parameter children with the parameter
+// and the following tokens inserted. Typed parameters are tried before
+// untyped ones and those before resource IDs.
+func (n *node) insertParam(t token, tokens []token, l *leaf) []*node {
+	params := append([]*node{}, n.params...)
+	for i, child := range params {
+		if child.name == t.text && child.typ == t.typ && child.empty == t.empty {
+			params[i] = child.insert(tokens, l)
+			return params
+		}
+	}
+	child := &node{
+		kind:  paramSegment,
+		name:  t.text,
+		typ:   t.typ,
+		check: t.check,
+		empty: t.empty,
+	}
+	params = append(params, child.insert(tokens, l))
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].rank() < params[j].rank()
+	})
+	return params
+}
+
+// rank returns the order of a parameter node.
+func (n *node) rank() int {
+	switch {
+	case n.check != nil:
+		return 0
+	case !n.empty:
+		return 1
+	}
+	return 2
+}
+
+// match looks for the leaf matching the path. The path is what remains
+// after the node matched its own part. Static children are tried first,
+// then parameters, and at last a catch-all. The matched parameters are
+// appended to the passed ones.
+func (n *node) match(path string, ps []paramValue) (*leaf, []paramValue) {
+	if path == "" && n.leaf != nil {
+		return n.leaf, ps
+	}
+	if path != "" {
+		for _, child := range n.statics {
+			if child.prefix[0] == path[0] && strings.HasPrefix(path, child.prefix) {
+				if l, cps := child.match(path[len(child.prefix):], ps); l != nil {
+					return l, cps
+				}
+			}
+		}
+	}
+	if len(n.params) > 0 {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		value := path[:end]
+		for _, child := range n.params {
+			if (value == "" && !child.empty) || (child.check != nil && !child.check(value)) {
+				continue
+			}
+			cps := ps
+			if child.name != "" {
+				cps = append(cps, paramValue{child.name, value})
+			}
+			if l, cps := child.match(path[end:], cps); l != nil {
+				return l, cps
+			}
+		}
+	}
+	if n.catchAll != nil {
+		if n.catchAll.name != "" {
+			ps = append(ps, paramValue{n.catchAll.name, path})
+		}
+		return n.catchAll.leaf, ps
+	}
+	return nil, ps
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b.
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+//--------------------
+// ROUTING TABLE
+//--------------------
+
+// routeTable contains the prefix, the registered routes, and the routing
+// tree build out of them. A table is never changed after being published.
+type routeTable struct {
+	prefix string
+	routes []*route
+	root   *node
+}
+
+// newRouteTable creates an empty route table for the prefix.
+func newRouteTable(prefix string) *routeTable {
+	return &routeTable{
+		prefix: prefix,
+		root:   &node{kind: staticSegment},
+	}
+}
+
+// withPrefix returns a copy of the table with a new prefix.
+func (t *routeTable) withPrefix(prefix string) *routeTable {
+	nt := *t
+	nt.prefix = prefix
+	return &nt
+}
+
+// with returns a copy of the table with the route added or replaced.
+func (t *routeTable) with(rt *route) *routeTable {
+	nt := &routeTable{
+		prefix: t.prefix,
+		routes: make([]*route, 0, len(t.routes)+1),
+		root:   t.root,
+	}
+	replaced := false
+	for _, ert := range t.routes {
+		if ert.path == rt.path {
+			nt.routes = append(nt.routes, rt)
+			replaced = true
+			continue
+		}
+		nt.routes = append(nt.routes, ert)
+	}
+	if !replaced {
+		nt.routes = append(nt.routes, rt)
+	}
+	tokenss, leaves := rt.variants()
+	for i, tokens := range tokenss {
+		nt.root = nt.root.insert(tokens, leaves[i])
+	}
+	return nt
+}
+
+// without returns a copy of the table without the route for the path. It
+// returns false if there's no such route. The tree is rebuilt out of the
+// remaining routes.
+func (t *routeTable) without(path string) (*routeTable, bool) {
+	nt := newRouteTable(t.prefix)
+	mountPath := "/" + strings.Trim(path, "/") + "/..."
+	removed := false
+	for _, rt := range t.routes {
+		if rt.path == path || (rt.mounted != nil && rt.path == mountPath) {
+			removed = true
+			continue
+		}
+		nt = nt.with(rt)
+	}
+	if !removed {
+		return t, false
+	}
+	return nt, true
+}
+
+// lookup returns the leaf matching the path without the prefix and the
+// matched parameters.
+func (t *routeTable) lookup(path string) (*leaf, PathParams) {
+	l, ps := t.root.match(trimPrefix(path, t.prefix), nil)
+	if l == nil {
+		return nil, nil
+	}
+	if len(ps) == 0 && l.fill == "" {
+		return l, nil
+	}
+	params := make(PathParams, len(ps)+1)
+	for _, p := range ps {
+		params[p.name] = p.value
+	}
+	if l.fill != "" {
+		params[l.fill] = ""
+	}
+	return l, params
+}
+
+// EOF
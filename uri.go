@@ -12,7 +12,15 @@ package httpx
 //--------------------
 
 import (
+	"context"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -44,26 +52,106 @@ func (ress Resources) IsPath(path string) bool {
 	return ress.Path() == path
 }
 
-// PathID first checks if the resources path matches a given path and
-// then returns the ID of that resource.
-func (ress Resources) PathID(path string) string {
-	names := strings.Split(path, "/")
-	for i, name := range names {
+// PathID checks if the resources path matches the given path completely
+// and returns the ID of its last resource. So for the resources of
+// /users/42/orders/7 the path users/orders returns 7. If the path doesn't
+// match false is returned.
+func (ress Resources) PathID(path string) (string, bool) {
+	if len(ress) == 0 || !ress.IsPath(path) {
+		return "", false
+	}
+	return ress[len(ress)-1].ID, true
+}
+
+// ID returns the ID of the named resource, e.g. of any ancestor of the last
+// resource. If a name is used multiple times the last one counts. If there's
+// no resource with the name false is returned.
+func (ress Resources) ID(name string) (string, bool) {
+	for i := len(ress) - 1; i >= 0; i-- {
 		if ress[i].Name == name {
-			return ress[i].ID
+			return ress[i].ID, true
 		}
 	}
-	return ""
+	return "", false
+}
+
+// ResourcesFromContext returns the resources of the request path stored in
+// the context by the nested multiplexer for routes registered with resource
+// paths.
+func ResourcesFromContext(ctx context.Context) (Resources, bool) {
+	rc, ok := ctx.Value(resourcesKey).(*routeContext)
+	if !ok {
+		return nil, false
+	}
+	return rc.resources(), true
+}
+
+// IDInt returns the ID of the named resource as int. Malformed IDs are
+// returned as StatusError with code 400.
+func (ress Resources) IDInt(name string) (int, error) {
+	id, err := ress.requiredID(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, StatusErrorf(http.StatusBadRequest, "ID %q of resource %q is no integer", id, name)
+	}
+	return i, nil
+}
+
+// IDInt64 returns the ID of the named resource as int64. Malformed IDs are
+// returned as StatusError with code 400.
+func (ress Resources) IDInt64(name string) (int64, error) {
+	id, err := ress.requiredID(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, StatusErrorf(http.StatusBadRequest, "ID %q of resource %q is no 64 bit integer", id, name)
+	}
+	return i, nil
+}
+
+// IDUUID returns the ID of the named resource as UUID in its binary form.
+// The ID has to be in the canonical textual form. Malformed IDs are returned
+// as StatusError with code 400.
+func (ress Resources) IDUUID(name string) ([16]byte, error) {
+	var uuid [16]byte
+	id, err := ress.requiredID(name)
+	if err != nil {
+		return uuid, err
+	}
+	if !isUUID(id) {
+		return uuid, StatusErrorf(http.StatusBadRequest, "ID %q of resource %q is no UUID", id, name)
+	}
+	_, err = hex.Decode(uuid[:], []byte(strings.ReplaceAll(id, "-", "")))
+	if err != nil {
+		return uuid, StatusErrorf(http.StatusBadRequest, "ID %q of resource %q is no UUID", id, name)
+	}
+	return uuid, nil
+}
+
+// requiredID returns the ID of the named resource. A missing resource is
+// returned as internal server error, an empty ID as bad request.
+func (ress Resources) requiredID(name string) (string, error) {
+	id, ok := ress.ID(name)
+	switch {
+	case !ok:
+		return "", StatusErrorf(http.StatusInternalServerError, "no resource %q in path", name)
+	case id == "":
+		return "", StatusErrorf(http.StatusBadRequest, "resource %q has no ID", name)
+	}
+	return id, nil
 }
 
 // PathToResources parses a new Resource from a URI path.
 func PathToResources(r *http.Request, prefix string) Resources {
-	// Remove prefix with and without trailing slash.
-	prefix = strings.TrimSuffix(prefix, "/")
-	trimmed := strings.TrimPrefix(r.URL.Path, prefix)
-	trimmed = strings.TrimPrefix(trimmed, "/")
-	// Now split the path.
-	parts := strings.Split(trimmed, "/")
+	// Remove prefix with and without trailing slash
+	// and split the escaped path, so that escaped
+	// slashes stay inside of names and IDs.
+	parts := strings.Split(trimPrefix(r.URL.EscapedPath(), prefix), "/")
 	if len(parts) == 0 {
 		return nil
 	}
@@ -72,9 +160,9 @@ func PathToResources(r *http.Request, prefix string) Resources {
 	for i, part := range parts {
 		switch {
 		case i%2 == 0:
-			name = part
+			name = unescapeSegment(part)
 		case i%2 == 1:
-			ress = append(ress, Resource{name, part})
+			ress = append(ress, Resource{name, unescapeSegment(part)})
 			name = ""
 		}
 	}
@@ -84,4 +172,481 @@ func PathToResources(r *http.Request, prefix string) Resources {
 	return ress
 }
 
+// ResourcesToPath creates a URI path out of the prefix and the resources.
+// It's the inverse of PathToResources. Names and IDs are escaped including
+// slashes, an empty ID of the last resource is left out.
+func ResourcesToPath(prefix string, ress Resources) string {
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSuffix(prefix, "/"))
+	for i, res := range ress {
+		sb.WriteString("/")
+		sb.WriteString(url.PathEscape(res.Name))
+		if res.ID == "" && i == len(ress)-1 {
+			break
+		}
+		sb.WriteString("/")
+		sb.WriteString(url.PathEscape(res.ID))
+	}
+	if sb.Len() == 0 {
+		return "/"
+	}
+	return sb.String()
+}
+
+//--------------------
+// URI TEMPLATES
+//--------------------
+
+// templateOperator describes the expansion of an expression by its
+// operator following RFC 6570.
+type templateOperator struct {
+	first         string
+	sep           string
+	named         bool
+	ifEmpty       string
+	allowReserved bool
+	class         string
+}
+
+// templateOperators contains the supported operators of URI template expressions.
+var templateOperators = map[byte]templateOperator{
+	0:   {"", ",", false, "", false, `[^/?#,]*`},
+	'+': {"", ",", false, "", true, `[^?#,]*`},
+	'#': {"#", ",", false, "", true, `[^,]*`},
+	'.': {".", ".", false, "", false, `[^/?#.]*`},
+	'/': {"/", "/", false, "", false, `[^/?#]*`},
+	';': {";", ";", true, "", false, `[^/?#;]*`},
+	'?': {"?", "&", true, "=", false, `[^#&]*`},
+	'&': {"&", "&", true, "=", false, `[^#&]*`},
+}
+
+// templateVar is a variable of a template expression.
+type templateVar struct {
+	name    string
+	prefix  int
+	explode bool
+}
+
+// templatePart is either a literal or an expression of a template.
+type templatePart struct {
+	literal string
+	op      byte
+	vars    []templateVar
+}
+
+// URITemplate is a URI template following RFC 6570 like
+// /api/v1/users/{user-id}/orders{?limit,offset}. It can be expanded
+// with variables and match paths. Additionally to the RFC variable
+// names may contain dashes.
+type URITemplate struct {
+	raw     string
+	parts   []templatePart
+	re      *regexp.Regexp
+	names   []string
+	queries []string
+}
+
+// ParseURITemplate parses a URI template. All expressions of level 4 are
+// supported.
+func ParseURITemplate(raw string) (*URITemplate, error) {
+	t := &URITemplate{
+		raw: raw,
+	}
+	rest := raw
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			open = len(rest)
+		}
+		if closing := strings.IndexByte(rest[:open], '}'); closing >= 0 {
+			return nil, fmt.Errorf("URITemplate: unexpected closing brace in %q", raw)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		if open == len(rest) {
+			break
+		}
+		closing := strings.IndexByte(rest[open:], '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("URITemplate: unclosed expression in %q", raw)
+		}
+		part, err := parseTemplateExpression(rest[open+1 : open+closing])
+		if err != nil {
+			return nil, fmt.Errorf("URITemplate: %v in %q", err, raw)
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[open+closing+1:]
+	}
+	if err := t.compile(); err != nil {
+		return nil, fmt.Errorf("URITemplate: cannot compile %q: %v", raw, err)
+	}
+	return t, nil
+}
+
+// MustParseURITemplate parses a URI template and panics in case of an error.
+func MustParseURITemplate(raw string) *URITemplate {
+	t, err := ParseURITemplate(raw)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the raw template.
+func (t *URITemplate) String() string {
+	return t.raw
+}
+
+// Expand expands the template with the given variables. Values may be
+// strings, slices, or maps. Other values are formatted with fmt.Sprint().
+// Map keys are expanded in sorted order. Missing variables, nil values,
+// and empty slices or maps are undefined and left out.
+func (t *URITemplate) Expand(vars map[string]interface{}) string {
+	var sb strings.Builder
+	for _, part := range t.parts {
+		if part.vars == nil {
+			sb.WriteString(part.literal)
+			continue
+		}
+		op := templateOperators[part.op]
+		first := true
+		for _, v := range part.vars {
+			value, ok := templateValue(vars[v.name])
+			if !ok {
+				continue
+			}
+			if first {
+				sb.WriteString(op.first)
+				first = false
+			} else {
+				sb.WriteString(op.sep)
+			}
+			v.expand(&sb, op, value)
+		}
+	}
+	return sb.String()
+}
+
+// Match matches the URI, a path optionally with query, against the template.
+// It returns the decoded values of the matched variables. Variables of query
+// expressions are taken from the query in any order. Exploded variables are
+// returned as they appear in the URI, e.g. a/b/c for {/list*}.
+func (t *URITemplate) Match(uri string) (map[string]string, bool) {
+	path, query := uri, ""
+	if len(t.queries) > 0 {
+		if idx := strings.IndexByte(uri, '?'); idx >= 0 {
+			path, query = uri[:idx], uri[idx+1:]
+		}
+	}
+	matches := t.re.FindStringSubmatch(path)
+	if matches == nil {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for i, name := range t.names {
+		if matches[i+1] == "" {
+			continue
+		}
+		value, err := url.PathUnescape(matches[i+1])
+		if err != nil {
+			return nil, false
+		}
+		values[name] = value
+	}
+	if len(t.queries) > 0 {
+		qvs, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, false
+		}
+		for _, name := range t.queries {
+			if qv, ok := qvs[name]; ok {
+				values[name] = strings.Join(qv, ",")
+			}
+		}
+	}
+	return values, true
+}
+
+// MatchRequest matches the escaped path and the query of the request
+// against the template.
+func (t *URITemplate) MatchRequest(r *http.Request) (map[string]string, bool) {
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+	return t.Match(uri)
+}
+
+// compile creates the regular expression for matching. Query expressions
+// are not part of it.
+func (t *URITemplate) compile() error {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, part := range t.parts {
+		if part.vars == nil {
+			sb.WriteString(regexp.QuoteMeta(part.literal))
+			continue
+		}
+		if part.op == '?' || part.op == '&' {
+			for _, v := range part.vars {
+				t.queries = append(t.queries, v.name)
+			}
+			continue
+		}
+		op := templateOperators[part.op]
+		class := op.class
+		for i, v := range part.vars {
+			if v.explode {
+				class = `[^?#]*`
+			}
+			sep := op.sep
+			if i == 0 {
+				sep = op.first
+			}
+			sb.WriteString("(?:")
+			sb.WriteString(regexp.QuoteMeta(sep))
+			if op.named {
+				sb.WriteString(regexp.QuoteMeta(v.name))
+				sb.WriteString("(?:=(" + class + "))?")
+			} else {
+				sb.WriteString("(" + class + ")")
+			}
+			t.names = append(t.names, v.name)
+		}
+		sb.WriteString(strings.Repeat(")?", len(part.vars)))
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return err
+	}
+	t.re = re
+	return nil
+}
+
+// parseTemplateExpression parses the content of a template expression.
+func parseTemplateExpression(expr string) (templatePart, error) {
+	part := templatePart{}
+	if expr == "" {
+		return part, fmt.Errorf("empty expression")
+	}
+	if _, ok := templateOperators[expr[0]]; ok {
+		part.op = expr[0]
+		expr = expr[1:]
+	} else if strings.IndexByte("=,!@|", expr[0]) >= 0 {
+		return part, fmt.Errorf("unsupported operator %q", expr[0])
+	}
+	for _, spec := range strings.Split(expr, ",") {
+		v := templateVar{}
+		switch {
+		case strings.HasSuffix(spec, "*"):
+			v.explode = true
+			spec = strings.TrimSuffix(spec, "*")
+		case strings.Contains(spec, ":"):
+			idx := strings.IndexByte(spec, ':')
+			prefix, err := strconv.Atoi(spec[idx+1:])
+			if err != nil || prefix < 1 || prefix > 9999 {
+				return part, fmt.Errorf("invalid prefix modifier %q", spec[idx+1:])
+			}
+			v.prefix = prefix
+			spec = spec[:idx]
+		}
+		if !isTemplateVarName(spec) {
+			return part, fmt.Errorf("invalid variable name %q", spec)
+		}
+		v.name = spec
+		part.vars = append(part.vars, v)
+	}
+	return part, nil
+}
+
+// isTemplateVarName checks if the name is a valid variable name. Dashes
+// are allowed too.
+func isTemplateVarName(name string) bool {
+	if name == "" || name[0] == '.' || name[len(name)-1] == '.' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		case c == '%' && i+2 < len(name) && isHexByte(name[i+1]) && isHexByte(name[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// expand writes the expanded value of the variable.
+func (v templateVar) expand(sb *strings.Builder, op templateOperator, value interface{}) {
+	switch tv := value.(type) {
+	case string:
+		if op.named {
+			sb.WriteString(v.name)
+			if tv == "" {
+				sb.WriteString(op.ifEmpty)
+				return
+			}
+			sb.WriteString("=")
+		}
+		if v.prefix > 0 {
+			if runes := []rune(tv); len(runes) > v.prefix {
+				tv = string(runes[:v.prefix])
+			}
+		}
+		sb.WriteString(encodeTemplateValue(tv, op.allowReserved))
+	case []string:
+		if !v.explode {
+			if op.named {
+				sb.WriteString(v.name + "=")
+			}
+			for i, item := range tv {
+				if i > 0 {
+					sb.WriteString(",")
+				}
+				sb.WriteString(encodeTemplateValue(item, op.allowReserved))
+			}
+			return
+		}
+		for i, item := range tv {
+			if i > 0 {
+				sb.WriteString(op.sep)
+			}
+			if op.named {
+				sb.WriteString(v.name)
+				if item == "" {
+					sb.WriteString(op.ifEmpty)
+					continue
+				}
+				sb.WriteString("=")
+			}
+			sb.WriteString(encodeTemplateValue(item, op.allowReserved))
+		}
+	case [][2]string:
+		if !v.explode {
+			if op.named {
+				sb.WriteString(v.name + "=")
+			}
+			for i, kv := range tv {
+				if i > 0 {
+					sb.WriteString(",")
+				}
+				sb.WriteString(encodeTemplateValue(kv[0], op.allowReserved))
+				sb.WriteString(",")
+				sb.WriteString(encodeTemplateValue(kv[1], op.allowReserved))
+			}
+			return
+		}
+		for i, kv := range tv {
+			if i > 0 {
+				sb.WriteString(op.sep)
+			}
+			sb.WriteString(encodeTemplateValue(kv[0], op.allowReserved))
+			if op.named && kv[1] == "" {
+				sb.WriteString(op.ifEmpty)
+				continue
+			}
+			sb.WriteString("=")
+			sb.WriteString(encodeTemplateValue(kv[1], op.allowReserved))
+		}
+	}
+}
+
+// templateValue converts a variable value into a string, a list of strings,
+// or a sorted list of key/value pairs. Undefined values return false.
+func templateValue(value interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+	switch tv := value.(type) {
+	case string:
+		return tv, true
+	case fmt.Stringer:
+		return tv.String(), true
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil, false
+		}
+		return templateValue(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Len() == 0 {
+			return nil, false
+		}
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(rv.Index(i).Interface())
+		}
+		return items, true
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return nil, false
+		}
+		kvs := make([][2]string, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			kvs = append(kvs, [2]string{fmt.Sprint(iter.Key().Interface()), fmt.Sprint(iter.Value().Interface())})
+		}
+		sort.Slice(kvs, func(i, j int) bool {
+			return kvs[i][0] < kvs[j][0]
+		})
+		return kvs, true
+	}
+	return fmt.Sprint(value), true
+}
+
+// encodeTemplateValue percent-encodes all characters of the value except
+// the unreserved ones. If reserved characters are allowed those and
+// percent-encoded triplets are kept too.
+func encodeTemplateValue(value string, allowReserved bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.IndexByte("-._~", c) >= 0:
+			sb.WriteByte(c)
+		case allowReserved && strings.IndexByte(":/?#[]@!$&'()*+,;=", c) >= 0:
+			sb.WriteByte(c)
+		case allowReserved && c == '%' && i+2 < len(value) && isHexByte(value[i+1]) && isHexByte(value[i+2]):
+			sb.WriteString(value[i : i+3])
+			i += 2
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// isHexByte checks if the byte is a hexadecimal digit.
+func isHexByte(c byte) bool {
+	return isHex(rune(c))
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// trimPrefix removes the prefix with and without trailing slash from the
+// path and also a leading slash of the remaining path.
+func trimPrefix(path, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	trimmed := strings.TrimPrefix(path, prefix)
+	return strings.TrimPrefix(trimmed, "/")
+}
+
+// unescapeSegment unescapes a segment of an escaped path. Invalid
+// escapes are kept as they are.
+func unescapeSegment(segment string) string {
+	unescaped, err := url.PathUnescape(segment)
+	if err != nil {
+		return segment
+	}
+	return unescaped
+}
+
 // EOF
@@ -0,0 +1,72 @@
+//go:build go1.18
+// +build go1.18
+
+// Tideland Go HTTP Extensions - Fuzz Tests
+//
+// Copyright (C) 2020-2022 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"tideland.dev/go/httpx"
+)
+
+//--------------------
+// FUZZING
+//--------------------
+
+// FuzzPathToResources checks that the lookups never panic for any parsed
+// path and that the parsed resources can be found again.
+func FuzzPathToResources(f *testing.F) {
+	for _, seed := range []string{
+		"/",
+		"/api/users",
+		"/api/users/42/orders/7",
+		"/api/users/42/orders/",
+		"/api//users///",
+		"/other/path",
+	} {
+		f.Add(seed, "users/orders")
+	}
+	f.Fuzz(func(t *testing.T, path, lookup string) {
+		req := &http.Request{URL: &url.URL{Path: path}}
+		ress := httpx.PathToResources(req, "/api")
+
+		// Arbitrary lookups must not panic.
+		ress.PathID(lookup)
+		ress.ID(lookup)
+		ress.IDInt(lookup)
+
+		if len(ress) == 0 {
+			if _, ok := ress.PathID(ress.Path()); ok {
+				t.Fatalf("empty resources of %q found by path", path)
+			}
+			return
+		}
+		id, ok := ress.PathID(ress.Path())
+		if !ok || id != ress[len(ress)-1].ID {
+			t.Fatalf("resources of %q not found by own path %q", path, ress.Path())
+		}
+		for _, res := range ress {
+			if _, ok := ress.ID(res.Name); !ok {
+				t.Fatalf("resource %q of %q not found by name", res.Name, path)
+			}
+		}
+		if _, ok := ress.PathID(ress.Path() + "/more"); ok {
+			t.Fatalf("resources of %q found by longer path", path)
+		}
+	})
+}
+
+// EOF
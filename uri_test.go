@@ -91,4 +91,298 @@ func TestPathToResources(t *testing.T) {
 	}
 }
 
+// TestResourcesToPath tests the creation of paths out of resources and
+// the round-trip with PathToResources.
+func TestResourcesToPath(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		prefix string
+		ress   httpx.Resources
+		path   string
+	}{
+		{
+			prefix: "",
+			path:   "/",
+		}, {
+			prefix: "/api/",
+			path:   "/api",
+		}, {
+			prefix: "/api",
+			ress:   httpx.Resources{{Name: "users"}},
+			path:   "/api/users",
+		}, {
+			prefix: "/api/",
+			ress:   httpx.Resources{{Name: "users", ID: "42"}},
+			path:   "/api/users/42",
+		}, {
+			prefix: "/api",
+			ress:   httpx.Resources{{Name: "users", ID: "42"}, {Name: "orders", ID: "7"}},
+			path:   "/api/users/42/orders/7",
+		}, {
+			prefix: "/api",
+			ress:   httpx.Resources{{Name: "users", ID: "42"}, {Name: "orders"}},
+			path:   "/api/users/42/orders",
+		}, {
+			prefix: "/api",
+			ress:   httpx.Resources{{Name: "users", ID: "John Doe"}},
+			path:   "/api/users/John%20Doe",
+		}, {
+			prefix: "/api",
+			ress:   httpx.Resources{{Name: "users", ID: "a/b"}, {Name: "orders", ID: "7"}},
+			path:   "/api/users/a%2Fb/orders/7",
+		}, {
+			prefix: "/api",
+			ress:   httpx.Resources{{Name: "users", ID: "a?b#c%d"}, {Name: "or/ders", ID: "50%"}},
+			path:   "/api/users/a%3Fb%23c%25d/or%2Fders/50%25",
+		},
+	}
+	for _, test := range tests {
+		assert.Logf("test %q with %v", test.prefix, test.ress)
+		path := httpx.ResourcesToPath(test.prefix, test.ress)
+		assert.Equal(path, test.path)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		assert.NoError(err)
+		ress := httpx.PathToResources(req, test.prefix)
+		assert.Length(ress, len(test.ress))
+		for i, res := range ress {
+			assert.Equal(res, test.ress[i])
+		}
+	}
+}
+
+// TestResourcesIDs tests the typed access to resource IDs.
+func TestResourcesIDs(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ress := httpx.Resources{
+		{Name: "users", ID: "42"},
+		{Name: "orders", ID: "0b4c1a6e-8d5a-4c8e-9a43-6f1e2d3c4b5a"},
+		{Name: "items", ID: "9223372036854775807"},
+		{Name: "notes"},
+	}
+
+	i, err := ress.IDInt("users")
+	assert.NoError(err)
+	assert.Equal(i, 42)
+	i64, err := ress.IDInt64("items")
+	assert.NoError(err)
+	assert.Equal(i64, int64(9223372036854775807))
+	uuid, err := ress.IDUUID("orders")
+	assert.NoError(err)
+	assert.Equal(uuid, [16]byte{0x0b, 0x4c, 0x1a, 0x6e, 0x8d, 0x5a, 0x4c, 0x8e, 0x9a, 0x43, 0x6f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5a})
+
+	_, err = ress.IDInt("orders")
+	assert.ErrorContains(err, `ID "0b4c1a6e-8d5a-4c8e-9a43-6f1e2d3c4b5a" of resource "orders" is no integer`)
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusBadRequest)
+	_, err = ress.IDUUID("users")
+	assert.ErrorContains(err, `ID "42" of resource "users" is no UUID`)
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusBadRequest)
+	_, err = ress.IDInt64("notes")
+	assert.ErrorContains(err, `resource "notes" has no ID`)
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusBadRequest)
+	_, err = ress.IDInt("customers")
+	assert.ErrorContains(err, `no resource "customers" in path`)
+	assert.Equal(httpx.ErrorStatusCode(err), http.StatusInternalServerError)
+}
+
+// TestResourcesLookup tests the lookup of IDs by path and by name.
+func TestResourcesLookup(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ress := httpx.Resources{
+		{Name: "users", ID: "42"},
+		{Name: "orders", ID: "7"},
+		{Name: "items"},
+	}
+
+	tests := []struct {
+		path string
+		id   string
+		ok   bool
+	}{
+		{"users/orders/items", "", true},
+		{"users/orders", "", false},
+		{"users", "", false},
+		{"users/orders/items/more", "", false},
+		{"orders/items", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		assert.Logf("path %q", test.path)
+		id, ok := ress.PathID(test.path)
+		assert.Equal(id, test.id)
+		assert.Equal(ok, test.ok)
+	}
+
+	id, ok := ress[:2].PathID("users/orders")
+	assert.True(ok)
+	assert.Equal(id, "7")
+
+	id, ok = ress.ID("users")
+	assert.True(ok)
+	assert.Equal(id, "42")
+	id, ok = ress.ID("orders")
+	assert.True(ok)
+	assert.Equal(id, "7")
+	id, ok = ress.ID("items")
+	assert.True(ok)
+	assert.Equal(id, "")
+	_, ok = ress.ID("customers")
+	assert.False(ok)
+
+	var empty httpx.Resources
+	_, ok = empty.PathID("")
+	assert.False(ok)
+	_, ok = empty.ID("users")
+	assert.False(ok)
+}
+
+// TestURITemplateExpand tests the expansion of URI templates with the
+// examples of RFC 6570.
+func TestURITemplateExpand(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	vars := map[string]interface{}{
+		"var":   "value",
+		"hello": "Hello World!",
+		"half":  "50%",
+		"empty": "",
+		"undef": nil,
+		"x":     1024,
+		"y":     "768",
+		"path":  "/foo/bar",
+		"base":  "http://example.com/home/",
+		"list":  []string{"red", "green", "blue"},
+		"keys":  map[string]string{"semi": ";", "dot": ".", "comma": ","},
+		"none":  []string{},
+	}
+
+	tests := []struct {
+		template string
+		expanded string
+	}{
+		{"{var}", "value"},
+		{"{hello}", "Hello%20World%21"},
+		{"{half}", "50%25"},
+		{"O{empty}X", "OX"},
+		{"O{undef}X", "OX"},
+		{"O{none}X", "OX"},
+		{"{x,y}", "1024,768"},
+		{"{x,hello,y}", "1024,Hello%20World%21,768"},
+		{"?{x,empty}", "?1024,"},
+		{"?{x,undef}", "?1024"},
+		{"?{undef,y}", "?768"},
+		{"{var:3}", "val"},
+		{"{var:30}", "value"},
+		{"{list}", "red,green,blue"},
+		{"{list*}", "red,green,blue"},
+		{"{keys}", "comma,%2C,dot,.,semi,%3B"},
+		{"{keys*}", "comma=%2C,dot=.,semi=%3B"},
+		{"{+var}", "value"},
+		{"{+hello}", "Hello%20World!"},
+		{"{+half}", "50%25"},
+		{"{base}index", "http%3A%2F%2Fexample.com%2Fhome%2Findex"},
+		{"{+base}index", "http://example.com/home/index"},
+		{"{+path}/here", "/foo/bar/here"},
+		{"here?ref={+path}", "here?ref=/foo/bar"},
+		{"{#var}", "#value"},
+		{"{#hello}", "#Hello%20World!"},
+		{"{#path:6}/here", "#/foo/b/here"},
+		{"X{.var}", "X.value"},
+		{"X{.list}", "X.red,green,blue"},
+		{"X{.list*}", "X.red.green.blue"},
+		{"{/var}", "/value"},
+		{"{/var,x}/here", "/value/1024/here"},
+		{"{/list*}", "/red/green/blue"},
+		{"{/list*,path:4}", "/red/green/blue/%2Ffoo"},
+		{"{;x,y}", ";x=1024;y=768"},
+		{"{;x,y,empty}", ";x=1024;y=768;empty"},
+		{"{;list*}", ";list=red;list=green;list=blue"},
+		{"{;keys*}", ";comma=%2C;dot=.;semi=%3B"},
+		{"{?x,y}", "?x=1024&y=768"},
+		{"{?x,y,empty}", "?x=1024&y=768&empty="},
+		{"{?list}", "?list=red,green,blue"},
+		{"{?list*}", "?list=red&list=green&list=blue"},
+		{"{?keys*}", "?comma=%2C&dot=.&semi=%3B"},
+		{"?fixed=yes{&x}", "?fixed=yes&x=1024"},
+		{"/users/{user-id}/orders{?undef}", "/users//orders"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.template)
+		tmpl, err := httpx.ParseURITemplate(test.template)
+		assert.NoError(err)
+		assert.Equal(tmpl.String(), test.template)
+		assert.Equal(tmpl.Expand(vars), test.expanded)
+	}
+}
+
+// TestURITemplateMatch tests the matching of paths against URI templates.
+func TestURITemplateMatch(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		template string
+		uri      string
+		values   map[string]string
+		ok       bool
+	}{
+		{"/users/{user-id}", "/users/42", map[string]string{"user-id": "42"}, true},
+		{"/users/{user-id}", "/users/a%20b", map[string]string{"user-id": "a b"}, true},
+		{"/users/{user-id}", "/users/42/orders", nil, false},
+		{"/users/{user-id}", "/customers/42", nil, false},
+		{"/users/{user-id}/orders/{order-id}", "/users/42/orders/7", map[string]string{"user-id": "42", "order-id": "7"}, true},
+		{"/users/{user-id}/orders{?limit,offset}", "/users/42/orders?offset=20&limit=10", map[string]string{"user-id": "42", "limit": "10", "offset": "20"}, true},
+		{"/users/{user-id}/orders{?limit,offset}", "/users/42/orders", map[string]string{"user-id": "42"}, true},
+		{"/files{/path*}", "/files/a/b/c", map[string]string{"path": "a/b/c"}, true},
+		{"/files{/dir,name}", "/files/docs/readme", map[string]string{"dir": "docs", "name": "readme"}, true},
+		{"/files{/dir,name}", "/files/docs", map[string]string{"dir": "docs"}, true},
+		{"/report{.format}", "/report.json", map[string]string{"format": "json"}, true},
+		{"/map{;lat,long}", "/map;lat=53.1;long=8.2", map[string]string{"lat": "53.1", "long": "8.2"}, true},
+		{"/static/{+rest}", "/static/css/main.css", map[string]string{"rest": "css/main.css"}, true},
+		{"/page{#section}", "/page#intro", map[string]string{"section": "intro"}, true},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s with %s", i, test.template, test.uri)
+		tmpl := httpx.MustParseURITemplate(test.template)
+		values, ok := tmpl.Match(test.uri)
+		assert.Equal(ok, test.ok)
+		if test.ok {
+			assert.Equal(values, test.values)
+		}
+	}
+
+	// Expanded templates have to match again.
+	tmpl := httpx.MustParseURITemplate("/users/{user-id}/orders/{order-id}{?limit}")
+	uri := tmpl.Expand(map[string]interface{}{"user-id": "a/b", "order-id": 7, "limit": 5})
+	assert.Equal(uri, "/users/a%2Fb/orders/7?limit=5")
+	values, ok := tmpl.Match(uri)
+	assert.True(ok)
+	assert.Equal(values, map[string]string{"user-id": "a/b", "order-id": "7", "limit": "5"})
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost"+uri, nil)
+	assert.NoError(err)
+	values, ok = tmpl.MatchRequest(req)
+	assert.True(ok)
+	assert.Equal(values["user-id"], "a/b")
+}
+
+// TestURITemplateInvalid tests the parsing of invalid URI templates.
+func TestURITemplateInvalid(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []string{
+		"/users/{id",
+		"/users/id}",
+		"/users/{}",
+		"/users/{=id}",
+		"/users/{id:0}",
+		"/users/{id:10000}",
+		"/users/{i d}",
+		"/users/{.}",
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test)
+		_, err := httpx.ParseURITemplate(test)
+		assert.ErrorContains(err, "URITemplate:")
+	}
+}
+
 // EOF